@@ -0,0 +1,135 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+)
+
+// ErrAcceptConfig indicates an invalid ACCEPT config.
+var ErrAcceptConfig = errors.New("invalid accept config")
+
+// acceptErrorClass decides how an accept loop reacts to an error.
+type acceptErrorClass string
+
+const (
+	// acceptClosed ends the accept loop without an error.
+	acceptClosed acceptErrorClass = "closed"
+	// acceptTimeout is retried immediately.
+	acceptTimeout acceptErrorClass = "timeout"
+	// acceptAborted means a single pending connection failed before it was
+	// accepted, it is retried immediately.
+	acceptAborted acceptErrorClass = "aborted"
+	// acceptExhausted means the process or system ran out of resources like
+	// file descriptors, it is retried with backoff.
+	acceptExhausted acceptErrorClass = "exhausted"
+	// acceptFatal stops Serve.
+	acceptFatal acceptErrorClass = "fatal"
+)
+
+func classifyAcceptError(err error) acceptErrorClass {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return acceptTimeout
+	}
+	switch {
+	case errors.Is(err, net.ErrClosed):
+		return acceptClosed
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE),
+		errors.Is(err, syscall.ENOBUFS), errors.Is(err, syscall.ENOMEM):
+		return acceptExhausted
+	case errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPROTO), errors.Is(err, syscall.EPERM),
+		errors.Is(err, syscall.EINTR), errors.Is(err, syscall.EAGAIN):
+		return acceptAborted
+	}
+	return acceptFatal
+}
+
+// acceptBackoff is the capped exponential delay between retries of an
+// accept loop. The zero delay means the last accept succeeded, each loop
+// starts over with a copy of Server.backoff after a successful accept.
+type acceptBackoff struct {
+	min   time.Duration
+	max   time.Duration
+	delay time.Duration
+}
+
+func (b *acceptBackoff) next() time.Duration {
+	if b.delay == 0 {
+		b.delay = b.min
+	} else {
+		b.delay *= 2
+	}
+	if b.delay > b.max {
+		b.delay = b.max
+	}
+	return b.delay
+}
+
+// parseAcceptBackoff reads ACCEPT.BACKOFFMIN and ACCEPT.BACKOFFMAX.
+func parseAcceptBackoff(ctx context.Context, conf *config.Config) (*acceptBackoff, error) {
+	acceptConfig, err := conf.GetConfig(ctx, "ACCEPT")
+	if err != nil {
+		return nil, fmt.Errorf("could not load accept config: %w", err)
+	}
+	minRaw, _ := acceptConfig.Get(ctx, "BACKOFFMIN")
+	minDelay, err := time.ParseDuration(minRaw)
+	if err != nil || minDelay <= 0 {
+		return nil, fmt.Errorf("%w: BACKOFFMIN %q", ErrAcceptConfig, minRaw)
+	}
+	maxRaw, _ := acceptConfig.Get(ctx, "BACKOFFMAX")
+	maxDelay, err := time.ParseDuration(maxRaw)
+	if err != nil || maxDelay < minDelay {
+		return nil, fmt.Errorf("%w: BACKOFFMAX %q", ErrAcceptConfig, maxRaw)
+	}
+	return &acceptBackoff{min: minDelay, max: maxDelay}, nil
+}
+
+func (s *Server) initAccept(ctx context.Context) error {
+	backoff, err := parseAcceptBackoff(ctx, s.Config)
+	if err != nil {
+		return err
+	}
+	s.backoff.Store(backoff)
+	return nil
+}
+
+// handleAcceptError reacts to a failed accept on l. It returns whether the
+// loop should keep accepting and the error Serve fails with otherwise.
+func (s *Server) handleAcceptError(ctx context.Context, l *listener, backoff *acceptBackoff, err error) (bool, error) {
+	class := classifyAcceptError(err)
+	switch class {
+	case acceptTimeout:
+		s.Logger.Debug(ctx, "Accept timed out")
+		return true, nil
+	case acceptClosed:
+		s.Logger.Debug(ctx, "Listener %s closed", l.name)
+		return false, nil
+	}
+
+	s.metrics.failed.With(s.metrics.name, l.name, string(class)).Inc()
+	switch class {
+	case acceptAborted:
+		s.Logger.Debug(ctx, "Accept on %s aborted: %s", l.name, err)
+		return true, nil
+	case acceptExhausted:
+		delay := backoff.next()
+		s.Logger.Error(ctx, "Accept on %s failed, retrying in %s: %s", l.name, delay, err)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		// a closed listener ends the loop on the next accept
+		select {
+		case <-timer.C:
+		case <-s.quit:
+		case <-ctx.Done():
+		}
+		return true, nil
+	}
+	s.Logger.Error(ctx, err.Error())
+	return false, fmt.Errorf("could not accept connection: %w", err)
+}
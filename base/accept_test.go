@@ -0,0 +1,121 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/myLogic207/pepper/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func acceptError(errno syscall.Errno) error {
+	return &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept4", errno)}
+}
+
+func TestClassifyAcceptError(t *testing.T) {
+	assert.Equal(t, acceptExhausted, classifyAcceptError(acceptError(syscall.EMFILE)))
+	assert.Equal(t, acceptExhausted, classifyAcceptError(acceptError(syscall.ENFILE)))
+	assert.Equal(t, acceptAborted, classifyAcceptError(acceptError(syscall.ECONNABORTED)))
+	assert.Equal(t, acceptClosed, classifyAcceptError(net.ErrClosed))
+	assert.Equal(t, acceptTimeout, classifyAcceptError(&net.OpError{Op: "accept", Err: os.ErrDeadlineExceeded}))
+	assert.Equal(t, acceptFatal, classifyAcceptError(acceptError(syscall.EINVAL)))
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	backoff := acceptBackoff{min: 5 * time.Millisecond, max: 30 * time.Millisecond}
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, backoff.next())
+	}
+	ms := time.Millisecond
+	assert.Equal(t, []time.Duration{5 * ms, 10 * ms, 20 * ms, 30 * ms, 30 * ms}, delays)
+}
+
+// failingListener returns the queued errors before accepting for real.
+type failingListener struct {
+	net.Listener
+	errs chan error
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	select {
+	case err := <-l.errs:
+		return nil, err
+	default:
+		return l.Listener.Accept()
+	}
+}
+
+func listenFailing(t *testing.T, registry *metrics.Registry, errs ...error) (*Server, <-chan error) {
+	t.Helper()
+	ctx := context.Background()
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{Metrics: registry}
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	failing := &failingListener{Listener: server.listeners[0].stream, errs: make(chan error, len(errs))}
+	for _, err := range errs {
+		failing.errs <- err
+	}
+	server.listeners[0].stream = failing
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, testHandle)
+	}()
+	return server, served
+}
+
+func TestAcceptRetriesExhaustion(t *testing.T) {
+	registry := metrics.NewRegistry()
+	emfile := acceptError(syscall.EMFILE)
+	server, served := listenFailing(t, registry, emfile, emfile, emfile, acceptError(syscall.ECONNABORTED))
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buff, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testMsg, buff)
+
+	exposed := &bytes.Buffer{}
+	if _, err := registry.WriteTo(exposed); err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, exposed.String(), `pepper_accept_errors_total{server="base",listener="default",class="exhausted"} 3`)
+	assert.Contains(t, exposed.String(), `pepper_accept_errors_total{server="base",listener="default",class="aborted"} 1`)
+
+	if err := server.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, <-served)
+}
+
+func TestAcceptFatalStopsServe(t *testing.T) {
+	fatal := acceptError(syscall.EINVAL)
+	_, served := listenFailing(t, nil, fatal)
+	select {
+	case err := <-served:
+		assert.True(t, errors.Is(err, syscall.EINVAL))
+	case <-time.After(time.Second):
+		t.Fatal("serve did not stop on a fatal accept error")
+	}
+}
@@ -0,0 +1,280 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+)
+
+// ErrRejected indicates that a connection was refused, see RejectReason.
+var ErrRejected = errors.New("connection rejected")
+
+// RejectReason describes why the server refused a connection.
+type RejectReason string
+
+const (
+	// RejectACL is used for sources matching the deny list or missing from
+	// the allow list.
+	RejectACL RejectReason = "acl"
+	// RejectPerIPLimit is used for sources exceeding ACL.MAXPERIP concurrent
+	// connections.
+	RejectPerIPLimit RejectReason = "per-ip-limit"
+	// RejectRateLimit is used for sources exceeding ACL.RATE new
+	// connections per second.
+	RejectRateLimit RejectReason = "rate-limit"
+	// RejectMaxConn is used for connections exceeding MAXCONN that could not
+	// be queued.
+	RejectMaxConn RejectReason = "max-conn"
+	// RejectQueueTimeout is used for queued connections that did not get a
+	// worker within OVERLOAD.QUEUETIMEOUT.
+	RejectQueueTimeout RejectReason = "queue-timeout"
+	// RejectVetoed is used for connections vetoed by Server.OnAccept.
+	RejectVetoed RejectReason = "vetoed"
+)
+
+// bucketPruneInterval is the interval in which idle rate limit buckets are
+// removed.
+const bucketPruneInterval = time.Minute
+
+// accessPolicy is an immutable snapshot of the ACL config.
+type accessPolicy struct {
+	allow    prefixList
+	deny     prefixList
+	maxPerIP int
+	rate     float64
+	burst    float64
+}
+
+func parseAccessPolicy(ctx context.Context, aclConfig *config.Config) (*accessPolicy, error) {
+	policy := &accessPolicy{maxPerIP: -1}
+	if aclConfig == nil {
+		return policy, nil
+	}
+	var err error
+	allowRaw, _ := aclConfig.Get(ctx, "ALLOW")
+	if policy.allow, err = parsePrefixList(allowRaw); err != nil {
+		return nil, fmt.Errorf("could not parse allow list: %w", err)
+	}
+	denyRaw, _ := aclConfig.Get(ctx, "DENY")
+	if policy.deny, err = parsePrefixList(denyRaw); err != nil {
+		return nil, fmt.Errorf("could not parse deny list: %w", err)
+	}
+	if maxPerIPRaw, err := aclConfig.Get(ctx, "MAXPERIP"); err == nil && maxPerIPRaw != "" {
+		if policy.maxPerIP, err = strconv.Atoi(maxPerIPRaw); err != nil {
+			return nil, fmt.Errorf("could not parse max connections per ip: %s", maxPerIPRaw)
+		}
+	}
+	if rateRaw, err := aclConfig.Get(ctx, "RATE"); err == nil && rateRaw != "" {
+		if policy.rate, err = strconv.ParseFloat(rateRaw, 64); err != nil {
+			return nil, fmt.Errorf("could not parse accept rate: %s", rateRaw)
+		}
+	}
+	if burstRaw, err := aclConfig.Get(ctx, "BURST"); err == nil && burstRaw != "" {
+		if policy.burst, err = strconv.ParseFloat(burstRaw, 64); err != nil {
+			return nil, fmt.Errorf("could not parse accept burst: %s", burstRaw)
+		}
+	}
+	if policy.burst <= 0 {
+		policy.burst = max(policy.rate, 1)
+	}
+	return policy, nil
+}
+
+// tokenBucket is a token bucket that is refilled lazily whenever tokens are
+// taken.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{tokens: burst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+// take removes n tokens if available.
+func (b *tokenBucket) take(now time.Time, rate, burst, n float64) bool {
+	b.refill(now, rate, burst)
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// accessControl enforces the ACL config. The policy can be replaced at any
+// time, counters and buckets are kept across updates.
+type accessControl struct {
+	sync.Mutex
+	policy   atomic.Pointer[accessPolicy]
+	active   map[netip.Addr]int
+	buckets  map[netip.Addr]*tokenBucket
+	rejected map[RejectReason]uint64
+}
+
+func newAccessControl(policy *accessPolicy) *accessControl {
+	ac := &accessControl{
+		active:   make(map[netip.Addr]int),
+		buckets:  make(map[netip.Addr]*tokenBucket),
+		rejected: make(map[RejectReason]uint64),
+	}
+	ac.policy.Store(policy)
+	return ac
+}
+
+// admit checks a new connection from ip. If it is admitted, release must be
+// called once the connection is closed.
+func (ac *accessControl) admit(ip netip.Addr, tracked bool) (release func(), reason RejectReason, ok bool) {
+	policy := ac.policy.Load()
+	if policy.deny.contains(ip) || (len(policy.allow) > 0 && !policy.allow.contains(ip)) {
+		return ac.reject(RejectACL)
+	}
+
+	ac.Lock()
+	defer ac.Unlock()
+	// the cap is checked first, so rejected connections keep their token
+	if tracked && policy.maxPerIP >= 0 && ac.active[ip] >= policy.maxPerIP {
+		ac.rejected[RejectPerIPLimit]++
+		return nil, RejectPerIPLimit, false
+	}
+	if policy.rate > 0 {
+		now := time.Now()
+		bucket, found := ac.buckets[ip]
+		if !found {
+			bucket = newTokenBucket(policy.burst, now)
+			ac.buckets[ip] = bucket
+		}
+		if !bucket.take(now, policy.rate, policy.burst, 1) {
+			ac.rejected[RejectRateLimit]++
+			return nil, RejectRateLimit, false
+		}
+	}
+	if !tracked {
+		return func() {}, "", true
+	}
+	ac.active[ip]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ac.Lock()
+			if ac.active[ip]--; ac.active[ip] <= 0 {
+				delete(ac.active, ip)
+			}
+			ac.Unlock()
+		})
+	}, "", true
+}
+
+func (ac *accessControl) reject(reason RejectReason) (func(), RejectReason, bool) {
+	ac.count(reason)
+	return nil, reason, false
+}
+
+func (ac *accessControl) count(reason RejectReason) {
+	ac.Lock()
+	ac.rejected[reason]++
+	ac.Unlock()
+}
+
+// pruneLoop removes idle rate limit buckets every bucketPruneInterval until
+// closed is closed.
+func (ac *accessControl) pruneLoop(closed <-chan struct{}) {
+	ticker := time.NewTicker(bucketPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case now := <-ticker.C:
+			ac.pruneBuckets(now)
+		}
+	}
+}
+
+// pruneBuckets drops buckets that are full again and thus carry no state.
+func (ac *accessControl) pruneBuckets(now time.Time) {
+	policy := ac.policy.Load()
+	ac.Lock()
+	defer ac.Unlock()
+	if policy.rate <= 0 {
+		clear(ac.buckets)
+		return
+	}
+	for ip, bucket := range ac.buckets {
+		bucket.refill(now, policy.rate, policy.burst)
+		if bucket.tokens >= policy.burst {
+			delete(ac.buckets, ip)
+		}
+	}
+}
+
+func (ac *accessControl) rejections() map[RejectReason]uint64 {
+	ac.Lock()
+	defer ac.Unlock()
+	rejected := make(map[RejectReason]uint64, len(ac.rejected))
+	for reason, count := range ac.rejected {
+		rejected[reason] = count
+	}
+	return rejected
+}
+
+func (s *Server) initAccessControl(ctx context.Context) error {
+	aclConfig, err := s.Config.GetConfig(ctx, "ACL")
+	if err != nil {
+		aclConfig = nil
+	}
+	policy, err := parseAccessPolicy(ctx, aclConfig)
+	if err != nil {
+		return err
+	}
+	s.acl = newAccessControl(policy)
+	return nil
+}
+
+// UpdateACL replaces the allow and deny lists and the per source limits of
+// a running server. Established connections are not affected.
+func (s *Server) UpdateACL(ctx context.Context, aclConfig *config.Config) error {
+	policy, err := parseAccessPolicy(ctx, aclConfig)
+	if err != nil {
+		return err
+	}
+	s.acl.policy.Store(policy)
+	s.Logger.Info(ctx, "ACL updated")
+	return nil
+}
+
+// Rejections returns the number of connections rejected per reason since
+// the server started listening.
+func (s *Server) Rejections() map[RejectReason]uint64 {
+	return s.acl.rejections()
+}
+
+// admit applies the ACL to a connection from addr. Sources without an IP,
+// like unix sockets, are always admitted. tracked enables the per source
+// connection limit, release must be called once a tracked connection is
+// closed.
+func (s *Server) admit(ctx context.Context, addr net.Addr, tracked bool) (func(), bool) {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return func() {}, true
+	}
+	release, reason, ok := s.acl.admit(ip, tracked)
+	if !ok {
+		s.Logger.Info(ctx, "Rejected connection from %s: %s", addr.String(), reason)
+		s.onReject(ctx, addr, reason)
+		return nil, false
+	}
+	return release, true
+}
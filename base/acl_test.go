@@ -0,0 +1,130 @@
+package base
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessControlLists(t *testing.T) {
+	ac := newAccessControl(&accessPolicy{
+		allow:    prefixList{netip.MustParsePrefix("10.0.0.0/8")},
+		deny:     prefixList{netip.MustParsePrefix("10.1.0.0/16")},
+		maxPerIP: -1,
+	})
+
+	_, _, ok := ac.admit(netip.MustParseAddr("10.2.3.4"), true)
+	assert.True(t, ok)
+	_, reason, ok := ac.admit(netip.MustParseAddr("10.1.2.3"), true)
+	assert.False(t, ok)
+	assert.Equal(t, RejectACL, reason)
+	_, reason, ok = ac.admit(netip.MustParseAddr("192.0.2.1"), true)
+	assert.False(t, ok)
+	assert.Equal(t, RejectACL, reason)
+	assert.Equal(t, map[RejectReason]uint64{RejectACL: 2}, ac.rejections())
+}
+
+func TestAccessControlPerIPLimit(t *testing.T) {
+	ac := newAccessControl(&accessPolicy{maxPerIP: 1})
+	ip := netip.MustParseAddr("192.0.2.1")
+
+	release, _, ok := ac.admit(ip, true)
+	assert.True(t, ok)
+	_, reason, ok := ac.admit(ip, true)
+	assert.False(t, ok)
+	assert.Equal(t, RejectPerIPLimit, reason)
+	_, _, ok = ac.admit(netip.MustParseAddr("192.0.2.2"), true)
+	assert.True(t, ok)
+
+	release()
+	release()
+	_, _, ok = ac.admit(ip, true)
+	assert.True(t, ok)
+}
+
+func TestAccessControlRateLimit(t *testing.T) {
+	ac := newAccessControl(&accessPolicy{maxPerIP: -1, rate: 1, burst: 2})
+	ip := netip.MustParseAddr("192.0.2.1")
+
+	for i := 0; i < 2; i++ {
+		_, _, ok := ac.admit(ip, false)
+		assert.True(t, ok)
+	}
+	_, reason, ok := ac.admit(ip, false)
+	assert.False(t, ok)
+	assert.Equal(t, RejectRateLimit, reason)
+
+	// one token is refilled per second
+	ac.buckets[ip].last = ac.buckets[ip].last.Add(-time.Second)
+	_, _, ok = ac.admit(ip, false)
+	assert.True(t, ok)
+}
+
+func TestAccessControlPerIPLimitKeepsToken(t *testing.T) {
+	ac := newAccessControl(&accessPolicy{maxPerIP: 1, rate: 1, burst: 2})
+	ip := netip.MustParseAddr("192.0.2.1")
+
+	release, _, ok := ac.admit(ip, true)
+	assert.True(t, ok)
+	for i := 0; i < 3; i++ {
+		_, reason, ok := ac.admit(ip, true)
+		assert.False(t, ok)
+		assert.Equal(t, RejectPerIPLimit, reason)
+	}
+	release()
+	// the rejected connections did not use up the remaining token
+	_, _, ok = ac.admit(ip, true)
+	assert.True(t, ok)
+}
+
+func TestAccessControlPruneBuckets(t *testing.T) {
+	ac := newAccessControl(&accessPolicy{maxPerIP: -1, rate: 1, burst: 2})
+	idle, busy := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+	ac.admit(idle, false)
+	ac.admit(busy, false)
+	ac.admit(busy, false)
+
+	ac.pruneBuckets(time.Now().Add(time.Second))
+	assert.NotContains(t, ac.buckets, idle)
+	assert.Contains(t, ac.buckets, busy)
+
+	ac.policy.Store(&accessPolicy{maxPerIP: -1})
+	ac.pruneBuckets(time.Now())
+	assert.Empty(t, ac.buckets)
+}
+
+func TestUpdateACL(t *testing.T) {
+	server, _ := startTestServer(t, testHandle)
+	defer server.Stop(context.Background())
+	addr := server.Addrs()[0].String()
+
+	read := func() []byte {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		buff, _ := io.ReadAll(conn)
+		return buff
+	}
+	assert.Equal(t, testMsg, read())
+
+	ctx := context.Background()
+	aclConfig, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"DENY": "127.0.0.0/8",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.UpdateACL(ctx, aclConfig); err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, read())
+	assert.Equal(t, uint64(1), server.Rejections()[RejectACL])
+}
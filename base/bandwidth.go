@@ -0,0 +1,315 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+)
+
+const contextKeyBandwidth = contextKey("bandwidth")
+
+// Bandwidth limits the traffic of a connection in bytes per second, a zero
+// rate is unlimited. Burst is the size of the token bucket in bytes and
+// defaults to one second of traffic. Reads and writes are split into chunks
+// of at most Burst bytes.
+type Bandwidth struct {
+	Read  float64
+	Write float64
+	Burst float64
+}
+
+type direction int
+
+const (
+	directionRead direction = iota
+	directionWrite
+)
+
+func (b Bandwidth) rate(dir direction) float64 {
+	if dir == directionRead {
+		return b.Read
+	}
+	return b.Write
+}
+
+func (b Bandwidth) burst(dir direction) float64 {
+	if b.Burst > 0 {
+		return b.Burst
+	}
+	return max(b.rate(dir), 1)
+}
+
+// bandwidthPolicy is an immutable snapshot of the BANDWIDTH config.
+type bandwidthPolicy struct {
+	// global is shared by all connections of the server
+	global Bandwidth
+	// perIP is shared by all connections from the same source
+	perIP Bandwidth
+	// conn applies to every connection unless the handler overrides it
+	conn Bandwidth
+}
+
+func parseBandwidth(ctx context.Context, bandwidthConfig *config.Config, prefix string) (Bandwidth, error) {
+	var limit Bandwidth
+	for key, value := range map[string]*float64{
+		"READ":  &limit.Read,
+		"WRITE": &limit.Write,
+		"BURST": &limit.Burst,
+	} {
+		raw, err := bandwidthConfig.Get(ctx, prefix+key)
+		if err != nil || raw == "" {
+			continue
+		}
+		if *value, err = strconv.ParseFloat(raw, 64); err != nil || *value < 0 {
+			return limit, fmt.Errorf("could not parse bandwidth %s: %s", prefix+key, raw)
+		}
+	}
+	return limit, nil
+}
+
+func parseBandwidthPolicy(ctx context.Context, bandwidthConfig *config.Config) (*bandwidthPolicy, error) {
+	policy := &bandwidthPolicy{}
+	if bandwidthConfig == nil {
+		return policy, nil
+	}
+	var err error
+	if policy.global, err = parseBandwidth(ctx, bandwidthConfig, ""); err != nil {
+		return nil, err
+	}
+	if policy.perIP, err = parseBandwidth(ctx, bandwidthConfig, "PERIP"); err != nil {
+		return nil, err
+	}
+	if policy.conn, err = parseBandwidth(ctx, bandwidthConfig, "CONN"); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// bandwidthBuckets are the token buckets of one scope. The zero value is a
+// pair of full buckets.
+type bandwidthBuckets struct {
+	read  tokenBucket
+	write tokenBucket
+}
+
+// reserve takes n bytes from the bucket of dir and returns how long the
+// caller has to wait until they are paid for. Unlimited directions return
+// immediately.
+func (b *bandwidthBuckets) reserve(now time.Time, limit Bandwidth, dir direction, n float64) time.Duration {
+	rate := limit.rate(dir)
+	if rate <= 0 {
+		return 0
+	}
+	bucket := &b.read
+	if dir == directionWrite {
+		bucket = &b.write
+	}
+	return bucket.reserve(now, rate, limit.burst(dir), n)
+}
+
+// reserve takes n tokens even if not enough are available and returns the
+// time until the debt is refilled.
+func (b *tokenBucket) reserve(now time.Time, rate, burst, n float64) time.Duration {
+	b.refill(now, rate, burst)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+type ipBandwidth struct {
+	bandwidthBuckets
+	conns int
+}
+
+// bandwidthShaper enforces the BANDWIDTH config. The policy can be replaced
+// at any time, buckets are kept across updates.
+type bandwidthShaper struct {
+	sync.Mutex
+	policy atomic.Pointer[bandwidthPolicy]
+	global bandwidthBuckets
+	ips    map[netip.Addr]*ipBandwidth
+}
+
+func newBandwidthShaper(policy *bandwidthPolicy) *bandwidthShaper {
+	bs := &bandwidthShaper{ips: make(map[netip.Addr]*ipBandwidth)}
+	bs.policy.Store(policy)
+	return bs
+}
+
+// shape wraps conn, release must be called once it is closed.
+func (bs *bandwidthShaper) shape(conn net.Conn) *shapedConn {
+	shaped := &shapedConn{Conn: conn, shaper: bs, done: make(chan struct{})}
+	if ip, ok := addrIP(conn.RemoteAddr()); ok {
+		bs.Lock()
+		perIP, found := bs.ips[ip]
+		if !found {
+			perIP = &ipBandwidth{}
+			bs.ips[ip] = perIP
+		}
+		perIP.conns++
+		bs.Unlock()
+		shaped.ip = ip
+		shaped.perIP = perIP
+	}
+	return shaped
+}
+
+// shapedConn delays reads and writes to stay within the global, per source
+// and connection limits.
+type shapedConn struct {
+	net.Conn
+	shaper      *bandwidthShaper
+	ip          netip.Addr
+	perIP       *ipBandwidth
+	buckets     bandwidthBuckets
+	override    atomic.Pointer[Bandwidth]
+	done        chan struct{}
+	releaseOnce sync.Once
+}
+
+func (c *shapedConn) limits() (global, perIP, conn Bandwidth) {
+	policy := c.shaper.policy.Load()
+	conn = policy.conn
+	if override := c.override.Load(); override != nil {
+		conn = *override
+	}
+	return policy.global, policy.perIP, conn
+}
+
+// chunk returns how many of n bytes may be transferred at once, which is the
+// smallest burst of all limited scopes.
+func (c *shapedConn) chunk(dir direction, n int) int {
+	global, perIP, conn := c.limits()
+	for _, limit := range []Bandwidth{global, perIP, conn} {
+		if limit.rate(dir) > 0 {
+			n = min(n, max(int(limit.burst(dir)), 1))
+		}
+	}
+	return n
+}
+
+func (c *shapedConn) reserve(dir direction, n int) time.Duration {
+	global, perIP, conn := c.limits()
+	if global.rate(dir) <= 0 && perIP.rate(dir) <= 0 && conn.rate(dir) <= 0 {
+		return 0
+	}
+	now := time.Now()
+	c.shaper.Lock()
+	defer c.shaper.Unlock()
+	delay := c.shaper.global.reserve(now, global, dir, float64(n))
+	if c.perIP != nil {
+		delay = max(delay, c.perIP.reserve(now, perIP, dir, float64(n)))
+	}
+	return max(delay, c.buckets.reserve(now, conn, dir, float64(n)))
+}
+
+// wait sleeps for delay or until the connection is released.
+func (c *shapedConn) wait(delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.done:
+		return net.ErrClosed
+	}
+}
+
+func (c *shapedConn) Read(b []byte) (int, error) {
+	if len(b) > 0 {
+		b = b[:c.chunk(directionRead, len(b))]
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		// the bytes are already read, the wait delays the next read
+		if waitErr := c.wait(c.reserve(directionRead, n)); err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (c *shapedConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		chunk := b[written:]
+		chunk = chunk[:c.chunk(directionWrite, len(chunk))]
+		if err := c.wait(c.reserve(directionWrite, len(chunk))); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// release stops pending waits and drops the per source state once the last
+// connection of a source is gone.
+func (c *shapedConn) release() {
+	c.releaseOnce.Do(func() {
+		close(c.done)
+		if c.perIP == nil {
+			return
+		}
+		c.shaper.Lock()
+		defer c.shaper.Unlock()
+		c.perIP.conns--
+		if c.perIP.conns == 0 {
+			delete(c.shaper.ips, c.ip)
+		}
+	})
+}
+
+// SetBandwidth replaces the BANDWIDTH.CONN limits of the connection handled
+// with ctx, e.g. once the tenant of the connection is known. The global and
+// per source limits still apply. It reports whether ctx belongs to a
+// connection of a stream server.
+func SetBandwidth(ctx context.Context, limit Bandwidth) bool {
+	shaped, ok := ctx.Value(contextKeyBandwidth).(*shapedConn)
+	if !ok {
+		return false
+	}
+	shaped.override.Store(&limit)
+	return true
+}
+
+func (s *Server) initBandwidth(ctx context.Context) error {
+	bandwidthConfig, err := s.Config.GetConfig(ctx, "BANDWIDTH")
+	if err != nil {
+		bandwidthConfig = nil
+	}
+	policy, err := parseBandwidthPolicy(ctx, bandwidthConfig)
+	if err != nil {
+		return err
+	}
+	s.bandwidth = newBandwidthShaper(policy)
+	return nil
+}
+
+// UpdateBandwidth replaces the bandwidth limits of a running server. They
+// apply to established connections as well, except for connections with
+// limits set by SetBandwidth.
+func (s *Server) UpdateBandwidth(ctx context.Context, bandwidthConfig *config.Config) error {
+	policy, err := parseBandwidthPolicy(ctx, bandwidthConfig)
+	if err != nil {
+		return err
+	}
+	s.bandwidth.policy.Store(policy)
+	s.Logger.Info(ctx, "Bandwidth limits updated")
+	return nil
+}
@@ -0,0 +1,99 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(100, now)
+	assert.Equal(t, time.Duration(0), bucket.reserve(now, 100, 100, 100))
+	assert.Equal(t, 500*time.Millisecond, bucket.reserve(now, 100, 100, 50))
+	// the debt is paid after half a second
+	assert.Equal(t, time.Duration(0), bucket.reserve(now.Add(time.Second), 100, 100, 50))
+}
+
+// remoteConn replaces the remote address of a connection.
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *remoteConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestBandwidthPerIP(t *testing.T) {
+	shaper := newBandwidthShaper(&bandwidthPolicy{perIP: Bandwidth{Write: 1000, Burst: 1000}})
+	source := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+	other := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1}
+	first := shaper.shape(&remoteConn{remote: source})
+	second := shaper.shape(&remoteConn{remote: source})
+	third := shaper.shape(&remoteConn{remote: other})
+
+	assert.Equal(t, time.Duration(0), first.reserve(directionWrite, 1000))
+	assert.Greater(t, second.reserve(directionWrite, 500), 400*time.Millisecond)
+	assert.Equal(t, time.Duration(0), third.reserve(directionWrite, 500))
+	assert.Equal(t, 1000, first.chunk(directionWrite, 5000))
+	assert.Equal(t, 5000, first.chunk(directionRead, 5000))
+
+	first.release()
+	second.release()
+	third.release()
+	assert.Empty(t, shaper.ips)
+}
+
+func timedRead(t *testing.T, addr string) ([]byte, time.Duration) {
+	t.Helper()
+	start := time.Now()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buff, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buff, time.Since(start)
+}
+
+func TestBandwidthConnLimit(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 5000)
+	override := make(chan bool, 2)
+	server := startHookServer(t, map[string]interface{}{
+		"BANDWIDTH": map[string]interface{}{
+			"CONNWRITE": 10000,
+			"CONNBURST": 1000,
+		},
+	}, func(s *Server) {}, func(ctx context.Context, conn net.Conn) error {
+		if <-override {
+			assert.True(t, SetBandwidth(ctx, Bandwidth{}))
+		}
+		_, err := conn.Write(payload)
+		return err
+	})
+	addr := server.Addrs()[0].String()
+
+	override <- false
+	buff, elapsed := timedRead(t, addr)
+	assert.Equal(t, payload, buff)
+	// the first 1000 bytes are covered by the burst
+	assert.GreaterOrEqual(t, elapsed, 350*time.Millisecond)
+
+	override <- true
+	buff, elapsed = timedRead(t, addr)
+	assert.Equal(t, payload, buff)
+	assert.Less(t, elapsed, 300*time.Millisecond)
+}
+
+func TestSetBandwidthWithoutConnection(t *testing.T) {
+	assert.False(t, SetBandwidth(context.Background(), Bandwidth{Read: 1}))
+}
@@ -0,0 +1,57 @@
+package basetest
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// AssertExchange writes send to conn and asserts that want is read back.
+// It only reads len(want) bytes, so the connection can be used further.
+func AssertExchange(tb testing.TB, conn net.Conn, send, want []byte) bool {
+	tb.Helper()
+	conn.SetDeadline(time.Now().Add(Timeout))
+	defer conn.SetDeadline(time.Time{})
+	if len(send) > 0 {
+		if _, err := conn.Write(send); err != nil {
+			return assert.Fail(tb, "could not write to connection", err.Error())
+		}
+	}
+	buff := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, buff); err != nil {
+		return assert.Fail(tb, "could not read from connection", "read %q: %s", buff, err.Error())
+	}
+	return assert.Equal(tb, want, buff)
+}
+
+// AssertReceived asserts that want is read from conn until the server
+// closes it, an empty want expects no data.
+func AssertReceived(tb testing.TB, conn net.Conn, want []byte) bool {
+	tb.Helper()
+	conn.SetReadDeadline(time.Now().Add(Timeout))
+	buff, err := io.ReadAll(conn)
+	if err != nil {
+		return assert.Fail(tb, "could not read from connection", "read %q: %s", buff, err.Error())
+	}
+	if len(want) == 0 {
+		return assert.Empty(tb, buff)
+	}
+	return assert.Equal(tb, want, buff)
+}
+
+// AssertHandlerError asserts that the next connection to close ended with
+// an error matching target, see errors.Is.
+func (s *Server) AssertHandlerError(target error) bool {
+	s.tb.Helper()
+	return assert.ErrorIs(s.tb, s.NextResult().Err, target)
+}
+
+// AssertHandlerSuccess asserts that the next connection to close ended
+// without an error.
+func (s *Server) AssertHandlerSuccess() bool {
+	s.tb.Helper()
+	return assert.NoError(s.tb, s.NextResult().Err)
+}
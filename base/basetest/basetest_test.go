@@ -0,0 +1,77 @@
+package basetest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/myLogic207/pepper/base"
+	"github.com/stretchr/testify/assert"
+)
+
+var errQuit = errors.New("client quit")
+
+// echoHandle echoes lines until it reads "quit".
+func echoHandle(ctx context.Context, conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "quit" {
+			return errQuit
+		}
+		if _, err := conn.Write([]byte(line)); err != nil {
+			return err
+		}
+	}
+}
+
+func TestServerExchange(t *testing.T) {
+	server := NewServer(t, nil, nil, echoHandle)
+	conn := server.Dial()
+	AssertExchange(t, conn, []byte("ping\n"), []byte("ping\n"))
+	AssertExchange(t, conn, []byte("pong\n"), []byte("pong\n"))
+	if _, err := conn.Write([]byte("quit\n")); err != nil {
+		t.Fatal(err)
+	}
+	AssertReceived(t, conn, nil)
+	server.AssertHandlerError(errQuit)
+	assert.Equal(t, "pipe", server.Addrs()[0].Network())
+}
+
+func TestServerHooksAndACL(t *testing.T) {
+	rejected := make(chan net.Addr, 1)
+	server := NewServer(t, &base.Server{
+		OnReject: func(ctx context.Context, addr net.Addr, reason base.RejectReason) {
+			rejected <- addr
+		},
+	}, map[string]interface{}{
+		"ACL": map[string]interface{}{
+			"DENY": "10.0.0.0/8",
+		},
+	}, func(ctx context.Context, conn net.Conn) error {
+		info, _ := base.ConnFromContext(ctx)
+		_, err := conn.Write([]byte(info.RemoteAddr.String()))
+		return err
+	})
+
+	AssertReceived(t, server.DialFrom(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}), nil)
+	assert.Equal(t, "10.0.0.1:4000", (<-rejected).String())
+
+	AssertReceived(t, server.DialFrom(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}), []byte("192.0.2.1:4000"))
+	server.AssertHandlerSuccess()
+}
+
+func TestListenerClosed(t *testing.T) {
+	listener := NewListener()
+	listener.Close()
+	_, err := listener.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+	_, err = listener.Dial(context.Background())
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
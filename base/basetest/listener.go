@@ -0,0 +1,90 @@
+// Package basetest provides an in-memory transport to unit test
+// base.HandleFunc implementations without sockets or sleeps.
+package basetest
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// pipeAddr is the address of both ends of an in-memory connection.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn reports the addresses of the listener and the dialer instead of
+// the placeholders of net.Pipe.
+type pipeConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+// Listener is a net.Listener whose connections are in-memory pipes created
+// by Dial. Set it as base.Server.Listener before Listen.
+type Listener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener creates an open Listener.
+func NewListener() *Listener {
+	return &Listener{
+		addr:   pipeAddr("basetest"),
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Accept waits for the next dialed connection. It returns net.ErrClosed
+// once the listener is closed.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// Dial connects to the listener. It returns once the connection was
+// accepted, so the server is ready to handle it.
+func (l *Listener) Dial(ctx context.Context) (net.Conn, error) {
+	return l.DialFrom(ctx, pipeAddr("client"))
+}
+
+// DialFrom connects to the listener like Dial with remote as the address
+// of the client, e.g. a *net.TCPAddr to test ACLs.
+func (l *Listener) DialFrom(ctx context.Context, remote net.Addr) (net.Conn, error) {
+	serverEnd, clientEnd := net.Pipe()
+	select {
+	case l.conns <- &pipeConn{Conn: serverEnd, local: l.addr, remote: remote}:
+		return &pipeConn{Conn: clientEnd, local: remote, remote: l.addr}, nil
+	case <-l.closed:
+		serverEnd.Close()
+		clientEnd.Close()
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		serverEnd.Close()
+		clientEnd.Close()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,152 @@
+package basetest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/myLogic207/pepper/base"
+)
+
+// Timeout bounds how long the helpers wait for the server and connections.
+var Timeout = 5 * time.Second
+
+// Result is the outcome of a served connection.
+type Result struct {
+	Info base.ConnectionInfo
+	// Err is the error that ended the connection, usually returned by the
+	// handler.
+	Err error
+}
+
+// Server is a base.Server serving on an in-memory Listener. It records the
+// Result of every connection it served.
+type Server struct {
+	*base.Server
+	Listener *Listener
+	tb       testing.TB
+	mu       sync.Mutex
+	results  []Result
+	closed   chan struct{}
+}
+
+// NewServer starts serving handle and returns once the server is ready.
+// server may be nil or carry hooks and middlewares, options are the server
+// config. The server is stopped when the test ends.
+func NewServer(tb testing.TB, server *base.Server, options map[string]interface{}, handle base.HandleFunc) *Server {
+	tb.Helper()
+	if server == nil {
+		server = &base.Server{}
+	}
+	if options == nil {
+		options = map[string]interface{}{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	tb.Cleanup(cancel)
+	conf, err := config.WithInitialValues(ctx, options)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	s := &Server{
+		Server:   server,
+		Listener: NewListener(),
+		tb:       tb,
+		closed:   make(chan struct{}, 1),
+	}
+	server.Listener = s.Listener
+	onClose := server.OnClose
+	server.OnClose = func(ctx context.Context, info base.ConnectionInfo, duration time.Duration, err error) {
+		if onClose != nil {
+			onClose(ctx, info, duration, err)
+		}
+		s.record(Result{Info: info, Err: err})
+	}
+	if err := server.Listen(ctx, conf); err != nil {
+		tb.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, handle)
+	}()
+	select {
+	case <-server.Ready():
+	case err := <-served:
+		tb.Fatalf("server stopped before it was ready: %v", err)
+	case <-time.After(Timeout):
+		tb.Fatalf("server not ready within %s", Timeout)
+	}
+	tb.Cleanup(func() {
+		server.Stop(ctx)
+		if err := <-served; err != nil {
+			tb.Errorf("server stopped with error: %v", err)
+		}
+	})
+	return s
+}
+
+func (s *Server) record(result Result) {
+	s.mu.Lock()
+	s.results = append(s.results, result)
+	s.mu.Unlock()
+	select {
+	case s.closed <- struct{}{}:
+	default:
+	}
+}
+
+// Dial connects to the server, the connection is closed when the test
+// ends.
+func (s *Server) Dial() net.Conn {
+	s.tb.Helper()
+	return s.DialFrom(nil)
+}
+
+// DialFrom connects to the server like Dial with remote as the address of
+// the client. A nil remote uses the default of Listener.Dial.
+func (s *Server) DialFrom(remote net.Addr) net.Conn {
+	s.tb.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if remote == nil {
+		conn, err = s.Listener.Dial(ctx)
+	} else {
+		conn, err = s.Listener.DialFrom(ctx, remote)
+	}
+	if err != nil {
+		s.tb.Fatalf("could not dial server: %v", err)
+	}
+	s.tb.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+// NextResult waits for the next served connection to close and returns
+// its Result, in the order the connections closed.
+func (s *Server) NextResult() Result {
+	s.tb.Helper()
+	timeout := time.After(Timeout)
+	for {
+		s.mu.Lock()
+		if len(s.results) > 0 {
+			result := s.results[0]
+			s.results = s.results[1:]
+			s.mu.Unlock()
+			return result
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.closed:
+		case <-timeout:
+			s.tb.Fatalf("no connection closed within %s", Timeout)
+			return Result{}
+		}
+	}
+}
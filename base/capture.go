@@ -0,0 +1,381 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+)
+
+// Capture files record the stream of a connection as seen by the handler,
+// that is after TLS termination and the PROXY protocol header. A file
+// starts with the 8 byte magic "PEPCAP01" followed by records of
+//
+//	type      1 byte, see CaptureRecordType
+//	timestamp 8 bytes, unix time in nanoseconds, big endian
+//	length    4 bytes, big endian
+//	data      length bytes
+//
+// The first record is of type CaptureMeta and holds "key=value" lines
+// describing the connection.
+
+var (
+	// ErrCaptureDisabled indicates that CAPTURE.DIR is not set.
+	ErrCaptureDisabled = errors.New("capture is disabled")
+	// ErrCaptureActive indicates that a connection is already captured.
+	ErrCaptureActive = errors.New("connection is already captured")
+	// ErrCaptureInactive indicates that a connection is not captured.
+	ErrCaptureInactive = errors.New("connection is not captured")
+	// ErrCaptureFormat indicates a file that is not a valid capture.
+	ErrCaptureFormat = errors.New("invalid capture file")
+)
+
+const captureMagic = "PEPCAP01"
+
+// CaptureRecordType is the type of a capture record.
+type CaptureRecordType byte
+
+const (
+	// CaptureMeta describes the connection.
+	CaptureMeta CaptureRecordType = 'M'
+	// CaptureRead holds bytes read from the client.
+	CaptureRead CaptureRecordType = 'R'
+	// CaptureWrite holds bytes written to the client.
+	CaptureWrite CaptureRecordType = 'W'
+)
+
+// CaptureRecord is a single record of a capture file.
+type CaptureRecord struct {
+	Type CaptureRecordType
+	Time time.Time
+	Data []byte
+}
+
+// CaptureReader reads the records of a capture file.
+type CaptureReader struct {
+	reader *bufio.Reader
+}
+
+// NewCaptureReader checks the magic of a capture file.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != captureMagic {
+		return nil, ErrCaptureFormat
+	}
+	return &CaptureReader{reader: reader}, nil
+}
+
+// Next returns the next record or io.EOF at the end of the file.
+func (cr *CaptureReader) Next() (CaptureRecord, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(cr.reader, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return CaptureRecord{}, io.EOF
+		}
+		return CaptureRecord{}, fmt.Errorf("%w: truncated record", ErrCaptureFormat)
+	}
+	record := CaptureRecord{
+		Type: CaptureRecordType(header[0]),
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(header[1:9]))),
+		Data: make([]byte, binary.BigEndian.Uint32(header[9:13])),
+	}
+	if _, err := io.ReadFull(cr.reader, record.Data); err != nil {
+		return CaptureRecord{}, fmt.Errorf("%w: truncated record", ErrCaptureFormat)
+	}
+	return record, nil
+}
+
+// captureFile writes the records of one connection. A failed write ends the
+// capture, the error is returned by close.
+type captureFile struct {
+	sync.Mutex
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	err    error
+}
+
+func createCaptureFile(dir string, info ConnectionInfo) (*captureFile, error) {
+	name := fmt.Sprintf("%s-%s.cap", time.Now().UTC().Format("20060102T150405.000000000Z"), info.ID)
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	cf := &captureFile{path: path, file: file, writer: bufio.NewWriter(file)}
+	cf.err = func() error {
+		if _, err := cf.writer.WriteString(captureMagic); err != nil {
+			return err
+		}
+		meta := &bytes.Buffer{}
+		fmt.Fprintf(meta, "id=%s\nlistener=%s\n", info.ID, info.Listener)
+		if info.RemoteAddr != nil {
+			fmt.Fprintf(meta, "remote=%s\n", info.RemoteAddr)
+		}
+		if info.LocalAddr != nil {
+			fmt.Fprintf(meta, "local=%s\n", info.LocalAddr)
+		}
+		fmt.Fprintf(meta, "started=%s\n", info.Started.UTC().Format(time.RFC3339Nano))
+		return cf.writeRecord(CaptureMeta, meta.Bytes())
+	}()
+	return cf, nil
+}
+
+func (cf *captureFile) writeRecord(recordType CaptureRecordType, data []byte) error {
+	header := make([]byte, 13)
+	header[0] = byte(recordType)
+	binary.BigEndian.PutUint64(header[1:9], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(data)))
+	if _, err := cf.writer.Write(header); err != nil {
+		return err
+	}
+	_, err := cf.writer.Write(data)
+	return err
+}
+
+func (cf *captureFile) record(recordType CaptureRecordType, data []byte) {
+	cf.Lock()
+	defer cf.Unlock()
+	if cf.err != nil {
+		return
+	}
+	cf.err = cf.writeRecord(recordType, data)
+}
+
+func (cf *captureFile) close() error {
+	cf.Lock()
+	defer cf.Unlock()
+	err := cf.err
+	if flushErr := cf.writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := cf.file.Close(); err == nil {
+		err = closeErr
+	}
+	// drop records of reads and writes racing with close
+	cf.err = os.ErrClosed
+	return err
+}
+
+// captureConn tees the stream into a capture file while one is attached.
+type captureConn struct {
+	net.Conn
+	file atomic.Pointer[captureFile]
+}
+
+func (c *captureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if cf := c.file.Load(); cf != nil && n > 0 {
+		cf.record(CaptureRead, b[:n])
+	}
+	return n, err
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if cf := c.file.Load(); cf != nil && n > 0 {
+		cf.record(CaptureWrite, b[:n])
+	}
+	return n, err
+}
+
+type captureOptions struct {
+	dir     string
+	sources prefixList
+}
+
+func parseCapture(ctx context.Context, conf *config.Config) (*captureOptions, error) {
+	options := &captureOptions{}
+	captureConfig, err := conf.GetConfig(ctx, "CAPTURE")
+	if err != nil || captureConfig == nil {
+		return options, nil
+	}
+	options.dir, _ = captureConfig.Get(ctx, "DIR")
+	sourcesRaw, _ := captureConfig.Get(ctx, "SOURCES")
+	if options.sources, err = parsePrefixList(sourcesRaw); err != nil {
+		return nil, fmt.Errorf("could not parse capture sources: %w", err)
+	}
+	return options, nil
+}
+
+func (s *Server) initCapture(ctx context.Context) error {
+	options, err := parseCapture(ctx, s.Config)
+	if err != nil {
+		return err
+	}
+	s.capture.Store(options)
+	return nil
+}
+
+// captureFromStart wraps conn and starts capturing if its source matches
+// CAPTURE.SOURCES.
+func (s *Server) captureFromStart(ctx context.Context, entry *connEntry, conn net.Conn) *captureConn {
+	captured := &captureConn{Conn: conn}
+	entry.Lock()
+	entry.capture = captured
+	entry.Unlock()
+	options := s.capture.Load()
+	if options.dir == "" {
+		return captured
+	}
+	if ip, ok := addrIP(conn.RemoteAddr()); ok && options.sources.contains(ip) {
+		if _, err := s.StartCapture(ctx, entry.id); err != nil {
+			s.Logger.Error(ctx, "Could not start capture of %s: %s", entry.id, err.Error())
+		}
+	}
+	return captured
+}
+
+// StartCapture starts writing the stream of an active connection into a new
+// file in CAPTURE.DIR and returns its path.
+func (s *Server) StartCapture(ctx context.Context, id ConnID) (string, error) {
+	dir := s.capture.Load().dir
+	if dir == "" {
+		return "", ErrCaptureDisabled
+	}
+	entry, captured, err := s.capturedEntry(id)
+	if err != nil {
+		return "", err
+	}
+	cf, err := createCaptureFile(dir, entry.info())
+	if err != nil {
+		return "", fmt.Errorf("could not create capture file: %w", err)
+	}
+	if !captured.file.CompareAndSwap(nil, cf) {
+		cf.close()
+		os.Remove(cf.path)
+		return "", fmt.Errorf("%w: %s", ErrCaptureActive, id)
+	}
+	s.Logger.Info(ctx, "Capturing %s to %s", id, cf.path)
+	return cf.path, nil
+}
+
+// StopCapture stops capturing a connection and closes the capture file.
+// Captures also end when the connection is closed.
+func (s *Server) StopCapture(ctx context.Context, id ConnID) error {
+	_, captured, err := s.capturedEntry(id)
+	if err != nil {
+		return err
+	}
+	cf := captured.file.Swap(nil)
+	if cf == nil {
+		return fmt.Errorf("%w: %s", ErrCaptureInactive, id)
+	}
+	s.Logger.Info(ctx, "Stopped capture of %s", id)
+	return cf.close()
+}
+
+func (s *Server) capturedEntry(id ConnID) (*connEntry, *captureConn, error) {
+	s.connMu.Lock()
+	entry, ok := s.conns[id]
+	s.connMu.Unlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownConnection, id)
+	}
+	entry.Lock()
+	captured := entry.capture
+	entry.Unlock()
+	if captured == nil {
+		// the connection did not reach its handler yet
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownConnection, id)
+	}
+	return entry, captured, nil
+}
+
+// endCapture closes the capture file of a connection that is done.
+func (s *Server) endCapture(ctx context.Context, captured *captureConn) {
+	if cf := captured.file.Swap(nil); cf != nil {
+		if err := cf.close(); err != nil {
+			s.Logger.Error(ctx, "Could not write capture %s: %s", cf.path, err.Error())
+		}
+	}
+}
+
+// ReplayResult is the outcome of Replay.
+type ReplayResult struct {
+	// Captured is the data written to the client in the capture.
+	Captured []byte
+	// Written is the data written by the replayed handler.
+	Written []byte
+}
+
+// Replay feeds the client data of a capture into handle and collects what
+// it writes, so a captured session can be reproduced in tests. The handler
+// reads io.EOF once all captured client data is consumed.
+func Replay(ctx context.Context, capture io.Reader, handle HandleFunc) (ReplayResult, error) {
+	reader, err := NewCaptureReader(capture)
+	if err != nil {
+		return ReplayResult{}, err
+	}
+	conn := &replayConn{}
+	captured := &bytes.Buffer{}
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return ReplayResult{}, err
+		}
+		switch record.Type {
+		case CaptureRead:
+			conn.input.Write(record.Data)
+		case CaptureWrite:
+			captured.Write(record.Data)
+		}
+	}
+	err = handle(ctx, conn)
+	return ReplayResult{Captured: captured.Bytes(), Written: conn.output.Bytes()}, err
+}
+
+// replayConn serves captured client data and records the written data.
+type replayConn struct {
+	sync.Mutex
+	input  bytes.Buffer
+	output bytes.Buffer
+	closed bool
+}
+
+var replayAddr = &net.UnixAddr{Name: "replay", Net: "unix"}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	return c.input.Read(b)
+}
+
+func (c *replayConn) Write(b []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	return c.output.Write(b)
+}
+
+func (c *replayConn) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *replayConn) LocalAddr() net.Addr                { return replayAddr }
+func (c *replayConn) RemoteAddr() net.Addr               { return replayAddr }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,148 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func echoLine(ctx context.Context, conn net.Conn) error {
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte("echo:" + line))
+	return err
+}
+
+func readCapture(t *testing.T, path string) []CaptureRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := NewCaptureReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []CaptureRecord
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return records
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestCaptureBySource(t *testing.T) {
+	dir := t.TempDir()
+	closed := make(chan struct{}, 1)
+	server := startHookServer(t, map[string]interface{}{
+		"CAPTURE": map[string]interface{}{
+			"DIR":     dir,
+			"SOURCES": "127.0.0.0/8",
+		},
+	}, func(s *Server) {
+		s.OnClose = func(ctx context.Context, info ConnectionInfo, duration time.Duration, err error) {
+			closed <- struct{}{}
+		}
+	}, echoLine)
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("ping\n"))
+	reply, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "echo:ping\n", string(reply))
+	<-closed
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*-conn-*.cap"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected one capture file, got %v (%v)", paths, err)
+	}
+	records := readCapture(t, paths[0])
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, CaptureMeta, records[0].Type)
+		assert.Contains(t, string(records[0].Data), "remote=127.0.0.1:")
+		assert.Equal(t, CaptureRecord{Type: CaptureRead, Time: records[1].Time, Data: []byte("ping\n")}, records[1])
+		assert.Equal(t, CaptureRecord{Type: CaptureWrite, Time: records[2].Time, Data: []byte("echo:ping\n")}, records[2])
+		assert.False(t, records[2].Time.Before(records[1].Time))
+	}
+
+	file, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	result, err := Replay(context.Background(), file, echoLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, result.Captured, result.Written)
+}
+
+func TestCaptureAtRuntime(t *testing.T) {
+	dir := t.TempDir()
+	handle, started, unblock := blockingHandle()
+	server := startHookServer(t, map[string]interface{}{
+		"CAPTURE": map[string]interface{}{
+			"DIR": dir,
+		},
+	}, func(s *Server) {}, handle)
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-started
+	id := server.Connections()[0].ID
+
+	path, err := server.StartCapture(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dir, filepath.Dir(path))
+	_, err = server.StartCapture(context.Background(), id)
+	assert.ErrorIs(t, err, ErrCaptureActive)
+	assert.NoError(t, server.StopCapture(context.Background(), id))
+	assert.ErrorIs(t, server.StopCapture(context.Background(), id), ErrCaptureInactive)
+	_, err = server.StartCapture(context.Background(), id+100)
+	assert.ErrorIs(t, err, ErrUnknownConnection)
+	close(unblock)
+
+	records := readCapture(t, path)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, CaptureMeta, records[0].Type)
+	}
+}
+
+func TestCaptureDisabled(t *testing.T) {
+	server, _ := startTestServer(t, testHandle)
+	defer server.Shutdown(context.Background())
+	_, err := server.StartCapture(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrCaptureDisabled)
+}
+
+func TestReplayInvalidCapture(t *testing.T) {
+	_, err := Replay(context.Background(), bytes.NewReader([]byte("not a capture")), echoLine)
+	assert.ErrorIs(t, err, ErrCaptureFormat)
+}
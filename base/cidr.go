@@ -0,0 +1,64 @@
+package base
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// prefixList is a list of CIDR ranges parsed from a comma separated config
+// value. Single addresses are accepted as /32 or /128 ranges.
+type prefixList []netip.Prefix
+
+func parsePrefixList(raw string) (prefixList, error) {
+	var prefixes prefixList
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse address %s: %w", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse cidr %s: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (pl prefixList) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range pl {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP returns the IP of a TCP or UDP address.
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, ok := netip.AddrFromSlice(a.IP)
+		return ip.Unmap(), ok
+	case *net.UDPAddr:
+		ip, ok := netip.AddrFromSlice(a.IP)
+		return ip.Unmap(), ok
+	default:
+		addrPort, err := netip.ParseAddrPort(addr.String())
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		return addrPort.Addr().Unmap(), true
+	}
+}
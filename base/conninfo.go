@@ -0,0 +1,37 @@
+package base
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+const contextKeyConnInfo = contextKey("conn-info")
+
+// ConnInfo describes the connection a handler is serving. It is complete
+// once the handler is called and must not be modified.
+type ConnInfo struct {
+	// ID identifies the connection in Server.Connections and Server.Kill.
+	ID       ConnID
+	Accepted time.Time
+	// Listener is the name of the listener that accepted the connection.
+	Listener string
+	// RemoteAddr is the address of the client, taken from the PROXY
+	// protocol header if present.
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	// TLS is the state of the TLS connection, nil without TLS.
+	TLS *tls.ConnectionState
+	// Proxy is the PROXY protocol header, nil if none was received.
+	Proxy *ProxyHeader
+	// PeerCredentials are the credentials of the peer process of a unix
+	// socket, nil for other networks.
+	PeerCredentials *PeerCredentials
+}
+
+// ConnFromContext returns the connection the handler is serving.
+func ConnFromContext(ctx context.Context) (*ConnInfo, bool) {
+	info, ok := ctx.Value(contextKeyConnInfo).(*ConnInfo)
+	return info, ok
+}
@@ -0,0 +1,42 @@
+package base
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnFromContext(t *testing.T) {
+	infos := make(chan *ConnInfo, 1)
+	server, _ := startTestServer(t, func(ctx context.Context, conn net.Conn) error {
+		info, _ := ConnFromContext(ctx)
+		infos <- info
+		return nil
+	})
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.ReadAll(conn)
+
+	info := <-infos
+	if info == nil {
+		t.Fatal("no connection info in context")
+	}
+	assert.NotZero(t, info.ID)
+	assert.False(t, info.Accepted.IsZero())
+	assert.Equal(t, "default", info.Listener)
+	assert.Equal(t, conn.LocalAddr().String(), info.RemoteAddr.String())
+	assert.Equal(t, conn.RemoteAddr().String(), info.LocalAddr.String())
+	assert.Nil(t, info.TLS)
+	assert.Nil(t, info.Proxy)
+	assert.Nil(t, info.PeerCredentials)
+
+	_, ok := ConnFromContext(context.Background())
+	assert.False(t, ok)
+}
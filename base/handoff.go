@@ -0,0 +1,202 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrHandoff indicates that the listeners could not be passed to a new
+	// process.
+	ErrHandoff = errors.New("could not hand off listeners")
+)
+
+const (
+	// EnvListenFDs lists the names of the listener sockets passed by
+	// Handoff, separated by colons. The sockets start at file descriptor 3.
+	EnvListenFDs = "PEPPER_LISTEN_FDS"
+
+	envSystemdListenFDs   = "LISTEN_FDS"
+	envSystemdListenPID   = "LISTEN_PID"
+	envSystemdListenNames = "LISTEN_FDNAMES"
+
+	// listenFDsStart is the first inherited file descriptor, following
+	// stdin, stdout and stderr.
+	listenFDsStart = 3
+)
+
+// inheritedListeners are the sockets passed by systemd socket activation or
+// by Handoff of a previous process. They belong to the process, every
+// server takes its own by name.
+type inheritedListeners struct {
+	mu    sync.Mutex
+	files []*os.File
+	names []string
+	used  []bool
+}
+
+var (
+	inheritOnce       sync.Once
+	processInherited  *inheritedListeners
+	processInheritErr error
+)
+
+// processListeners returns the inherited sockets, the environment is only
+// read by the first call.
+func processListeners() (*inheritedListeners, error) {
+	inheritOnce.Do(func() {
+		processInherited, processInheritErr = inheritListeners()
+	})
+	return processInherited, processInheritErr
+}
+
+// inheritListeners picks up inherited sockets from the environment. The
+// variables are removed afterwards, so they are not passed on to children.
+// Handoff takes precedence over systemd, as the PID of an exec'd process is
+// not known in advance.
+func inheritListeners() (*inheritedListeners, error) {
+	var count int
+	var names []string
+	if raw, ok := os.LookupEnv(EnvListenFDs); ok {
+		names = strings.Split(raw, ":")
+		count = len(names)
+	} else if pid, err := strconv.Atoi(os.Getenv(envSystemdListenPID)); err == nil && pid == os.Getpid() {
+		if count, err = strconv.Atoi(os.Getenv(envSystemdListenFDs)); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", envSystemdListenFDs, err)
+		}
+		if raw := os.Getenv(envSystemdListenNames); raw != "" {
+			names = strings.Split(raw, ":")
+		}
+		if len(names) != count {
+			names = nil
+		}
+	}
+	for _, key := range []string{EnvListenFDs, envSystemdListenFDs, envSystemdListenPID, envSystemdListenNames} {
+		os.Unsetenv(key)
+	}
+
+	inherited := &inheritedListeners{names: names, used: make([]bool, count)}
+	for i := 0; i < count; i++ {
+		fd := uintptr(listenFDsStart + i)
+		inherited.files = append(inherited.files, os.NewFile(fd, "listener-"+strconv.Itoa(i)))
+	}
+	return inherited, nil
+}
+
+// take returns the socket for the named listener, the caller closes it.
+// Without names the listeners are matched by their position.
+func (il *inheritedListeners) take(name string, index int) *os.File {
+	if il == nil {
+		return nil
+	}
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	if il.names != nil {
+		for i, inheritedName := range il.names {
+			if inheritedName == name && !il.used[i] {
+				il.used[i] = true
+				return il.files[i]
+			}
+		}
+		return nil
+	}
+	if index < len(il.files) && !il.used[index] {
+		il.used[index] = true
+		return il.files[index]
+	}
+	return nil
+}
+
+// fromFile creates the listener socket from an inherited file.
+func (l *listener) fromFile(file *os.File) error {
+	if isPacketNetwork(l.network) {
+		packetConn, err := net.FilePacketConn(file)
+		if err != nil {
+			return err
+		}
+		l.packetConn = packetConn
+		return nil
+	}
+	stream, err := net.FileListener(file)
+	if err != nil {
+		return err
+	}
+	l.stream = stream
+	return nil
+}
+
+// file returns a duplicate of the listener socket.
+func (l *listener) file() (*os.File, error) {
+	var socket any = l.stream
+	if l.stream == nil {
+		socket = l.packetConn
+	}
+	filer, ok := socket.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("%w: listener %s does not support file descriptors", ErrHandoff, l.name)
+	}
+	return filer.File()
+}
+
+// Handoff starts cmd with all listener sockets and gracefully shuts the
+// server down once the process started, see Shutdown. The new process picks
+// the sockets up in Listen, by listener name, so it must be configured with
+// the same LISTENERS. Pending connections stay in the shared accept queue,
+// no connection is refused during the handoff. Unix socket files are left in
+// place for the new process.
+func (s *Server) Handoff(ctx context.Context, cmd *exec.Cmd) (DrainReport, error) {
+	listeners := s.currentListeners()
+	files := make([]*os.File, 0, len(listeners))
+	names := make([]string, 0, len(listeners))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, l := range listeners {
+		file, err := l.file()
+		if err != nil {
+			return DrainReport{}, err
+		}
+		files = append(files, file)
+		names = append(names, l.name)
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = make([]string, 0, len(env)+1)
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		switch key {
+		case EnvListenFDs, envSystemdListenFDs, envSystemdListenPID, envSystemdListenNames:
+			continue
+		}
+		cmd.Env = append(cmd.Env, entry)
+	}
+	cmd.Env = append(cmd.Env, EnvListenFDs+"="+strings.Join(names, ":"))
+	// the sockets must be the first extra files to start at descriptor 3
+	cmd.ExtraFiles = append(files, cmd.ExtraFiles...)
+
+	if err := cmd.Start(); err != nil {
+		return DrainReport{}, fmt.Errorf("%w: %w", ErrHandoff, err)
+	}
+	s.Logger.Info(ctx, "Handed off %d listeners to process %d", len(files), cmd.Process.Pid)
+
+	for _, l := range listeners {
+		if unixListener, ok := l.stream.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+		// the socket file now belongs to the new process
+		l.socketCleanup.Do(func() {})
+	}
+	return s.Shutdown(ctx)
+}
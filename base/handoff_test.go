@@ -0,0 +1,123 @@
+package base
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const envHandoffChild = "PEPPER_TEST_HANDOFF_CHILD"
+
+func handoffConfig(t *testing.T) *config.Config {
+	t.Helper()
+	conf, err := config.WithInitialValues(context.Background(), map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+// TestHandoffChild is run by TestHandoff in a child process, it serves the
+// inherited listener until it is killed.
+func TestHandoffChild(t *testing.T) {
+	if os.Getenv(envHandoffChild) == "" {
+		t.Skip("only run as handoff child")
+	}
+	ctx := context.Background()
+	server := &Server{}
+	if err := server.Listen(ctx, handoffConfig(t)); err != nil {
+		t.Fatal(err)
+	}
+	server.Serve(ctx, func(ctx context.Context, conn net.Conn) error {
+		_, err := conn.Write([]byte("child"))
+		return err
+	})
+}
+
+func TestHandoff(t *testing.T) {
+	ctx := context.Background()
+	server := &Server{}
+	if err := server.Listen(ctx, handoffConfig(t)); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ctx, func(ctx context.Context, conn net.Conn) error {
+		_, err := conn.Write([]byte("parent"))
+		return err
+	})
+	addr := server.Addrs()[0].String()
+	read := func() string {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buff, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buff)
+	}
+	assert.Equal(t, "parent", read())
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandoffChild$")
+	cmd.Env = append(os.Environ(), envHandoffChild+"=1")
+	shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	report, err := server.Handoff(shutdownCtx, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	assert.Equal(t, 0, report.Killed)
+
+	// the old process stopped accepting, all connections reach the child
+	assert.Equal(t, "child", read())
+	assert.Equal(t, "child", read())
+}
+
+func TestInheritedListenersTake(t *testing.T) {
+	inherited := &inheritedListeners{names: []string{"a", "b"}, used: make([]bool, 2)}
+	for range inherited.names {
+		socket, err := net.Listen("tcp", tADDRESS+":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer socket.Close()
+		file, err := socket.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inherited.files = append(inherited.files, file)
+	}
+
+	// one server takes its socket and closes it after use
+	first := inherited.take("b", 0)
+	assert.Equal(t, inherited.files[1], first)
+	first.Close()
+	assert.Nil(t, inherited.take("b", 0))
+
+	// the socket of another server stays open until it is taken
+	second := inherited.take("a", 0)
+	assert.Equal(t, inherited.files[0], second)
+	listener, err := net.FileListener(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Close()
+	listener.Close()
+	assert.Nil(t, inherited.take("c", 2))
+}
@@ -0,0 +1,56 @@
+package base
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Hooks are called synchronously on connection lifecycle events. They are
+// set as fields of Server before Serve and must not block.
+type (
+	// AcceptHook is called for every admitted connection before it waits for
+	// a worker. Returning an error rejects the connection with RejectVetoed.
+	AcceptHook func(ctx context.Context, conn net.Conn) error
+	// RejectHook is called for every connection the server refuses.
+	RejectHook func(ctx context.Context, addr net.Addr, reason RejectReason)
+	// HandlerErrorHook is called with the error returned by the handler.
+	HandlerErrorHook func(ctx context.Context, conn net.Conn, err error)
+	// CloseHook is called once a served connection is closed, with the time
+	// it was open and the error that ended it, if any.
+	CloseHook func(ctx context.Context, info ConnectionInfo, duration time.Duration, err error)
+)
+
+// onAccept runs the OnAccept hook and reports whether the connection may be
+// served.
+func (s *Server) onAccept(ctx context.Context, conn net.Conn) bool {
+	if s.OnAccept == nil {
+		return true
+	}
+	if err := s.OnAccept(ctx, conn); err != nil {
+		s.acl.count(RejectVetoed)
+		s.Logger.Info(ctx, "Rejected connection from %s: %s", conn.RemoteAddr().String(), err.Error())
+		s.onReject(ctx, conn.RemoteAddr(), RejectVetoed)
+		return false
+	}
+	return true
+}
+
+func (s *Server) onReject(ctx context.Context, addr net.Addr, reason RejectReason) {
+	s.metrics.rejected.With(s.metrics.name, string(reason)).Inc()
+	if s.OnReject != nil {
+		s.OnReject(ctx, addr, reason)
+	}
+}
+
+func (s *Server) onHandlerError(ctx context.Context, conn net.Conn, err error) {
+	if s.OnHandlerError != nil {
+		s.OnHandlerError(ctx, conn, err)
+	}
+}
+
+func (s *Server) onClose(ctx context.Context, entry *connEntry, err error) {
+	if s.OnClose != nil {
+		s.OnClose(ctx, entry.info(), time.Since(entry.started), err)
+	}
+}
@@ -0,0 +1,121 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func startHookServer(t *testing.T, options map[string]interface{}, setup func(*Server), handle HandleFunc) *Server {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	options["ADDRESS"] = tADDRESS
+	options["PORT"] = tPORT
+	conf, err := config.WithInitialValues(ctx, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	setup(server)
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := server.Serve(ctx, handle); err != nil {
+			panic(err)
+		}
+	}()
+	return server
+}
+
+type closeEvent struct {
+	info     ConnectionInfo
+	duration time.Duration
+	err      error
+}
+
+func TestHooksLifecycle(t *testing.T) {
+	errHandler := errors.New("handler failed")
+	accepted := make(chan net.Addr, 1)
+	handlerErrs := make(chan error, 1)
+	closed := make(chan closeEvent, 1)
+	server := startHookServer(t, map[string]interface{}{}, func(s *Server) {
+		s.OnAccept = func(ctx context.Context, conn net.Conn) error {
+			accepted <- conn.RemoteAddr()
+			return nil
+		}
+		s.OnHandlerError = func(ctx context.Context, conn net.Conn, err error) {
+			handlerErrs <- err
+		}
+		s.OnClose = func(ctx context.Context, info ConnectionInfo, duration time.Duration, err error) {
+			closed <- closeEvent{info: info, duration: duration, err: err}
+		}
+	}, func(ctx context.Context, conn net.Conn) error {
+		if err := testHandle(ctx, conn); err != nil {
+			return err
+		}
+		return errHandler
+	})
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buff, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, testMsg, buff)
+
+	assert.Equal(t, conn.LocalAddr().String(), (<-accepted).String())
+	assert.ErrorIs(t, <-handlerErrs, errHandler)
+	event := <-closed
+	assert.ErrorIs(t, event.err, errHandler)
+	assert.Greater(t, event.duration, time.Duration(0))
+	assert.Equal(t, uint64(len(testMsg)), event.info.BytesOut)
+}
+
+func TestHooksReject(t *testing.T) {
+	rejected := make(chan RejectReason, 1)
+	server := startHookServer(t, map[string]interface{}{}, func(s *Server) {
+		s.OnAccept = func(ctx context.Context, conn net.Conn) error {
+			return errors.New("maintenance")
+		}
+		s.OnReject = func(ctx context.Context, addr net.Addr, reason RejectReason) {
+			rejected <- reason
+		}
+	}, testHandle)
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buff, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Empty(t, buff)
+	assert.Equal(t, RejectVetoed, <-rejected)
+	assert.Equal(t, uint64(1), server.Rejections()[RejectVetoed])
+
+	ctx := context.Background()
+	aclConfig, err := config.WithInitialValues(ctx, map[string]interface{}{"DENY": tADDRESS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.UpdateACL(ctx, aclConfig); err != nil {
+		t.Fatal(err)
+	}
+	conn, err = net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.ReadAll(conn)
+	assert.Equal(t, RejectACL, <-rejected)
+}
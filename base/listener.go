@@ -0,0 +1,345 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/myLogic207/pepper/internal/confutil"
+)
+
+var (
+	// ErrMixedNetworks indicates that stream and packet listeners were
+	// configured on the same server.
+	ErrMixedNetworks = errors.New("stream and packet listeners can not be mixed")
+)
+
+// listener is a single address the server is bound to. Either stream or
+// packetConn is set, depending on the network type. Reload rebinds the
+// listener once the configured address changes and replaces tls and proxy
+// otherwise.
+type listener struct {
+	name          string
+	network       string
+	address       string
+	stream        net.Listener
+	packetConn    net.PacketConn
+	tls           atomic.Pointer[tlsProvider]
+	proxy         atomic.Pointer[proxyOptions]
+	socketPath    string
+	socketCleanup sync.Once
+}
+
+func (l *listener) Addr() net.Addr {
+	if l.stream != nil {
+		return l.stream.Addr()
+	}
+	return l.packetConn.LocalAddr()
+}
+
+func (l *listener) close() error {
+	var err error
+	if l.stream != nil {
+		err = l.stream.Close()
+	} else if l.packetConn != nil {
+		err = l.packetConn.Close()
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return l.removeUnixSocket()
+}
+
+// listenerConfig resolves the settings of a single LISTENERS entry. Keys not
+// set in the entry fall back to the top level server config, nested configs
+// like TLS are inherited as a whole.
+type listenerConfig struct {
+	entry *config.Config
+	root  *config.Config
+}
+
+func (lc listenerConfig) Get(ctx context.Context, key string) string {
+	if lc.entry != nil {
+		if value, err := lc.entry.Get(ctx, key); err == nil {
+			return value
+		}
+	}
+	value, _ := lc.root.Get(ctx, key)
+	return value
+}
+
+func (lc listenerConfig) GetConfig(ctx context.Context, key string) (*config.Config, error) {
+	if lc.entry != nil {
+		if value := confutil.Section(ctx, lc.entry, key); value != nil {
+			return value, nil
+		}
+	}
+	return lc.root.GetConfig(ctx, key)
+}
+
+// name returns the NAME of the listener at index i, "default" for the
+// listener formed by the top level config.
+func (lc listenerConfig) name(ctx context.Context, i int) string {
+	name := lc.Get(ctx, "NAME")
+	if name == "" && lc.entry == nil {
+		return "default"
+	} else if name == "" {
+		return strconv.Itoa(i)
+	}
+	return name
+}
+
+// listenerConfigs returns the configured LISTENERS entries of root.
+// LISTENERS is a list keyed by index ("0", "1", ...); without it the top
+// level ADDRESS, PORT and TYPE form the only listener.
+func listenerConfigs(ctx context.Context, root *config.Config) []listenerConfig {
+	listeners := confutil.Section(ctx, root, "LISTENERS")
+	if listeners == nil {
+		return []listenerConfig{{root: root}}
+	}
+	var configs []listenerConfig
+	for i := 0; ; i++ {
+		entry := confutil.Section(ctx, listeners, strconv.Itoa(i))
+		if entry == nil {
+			break
+		}
+		configs = append(configs, listenerConfig{entry: entry, root: root})
+	}
+	if len(configs) == 0 {
+		return []listenerConfig{{root: root}}
+	}
+	return configs
+}
+
+// parseTimeout reads TIMEOUT, which bounds handshakes and sets the keep
+// alive period of new listeners.
+func parseTimeout(ctx context.Context, conf *config.Config) (time.Duration, error) {
+	timeoutRaw, _ := conf.Get(ctx, "TIMEOUT")
+	timeout, err := time.ParseDuration(timeoutRaw)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse timeout: %w", err)
+	}
+	return timeout, nil
+}
+
+func newListenConfig(timeout time.Duration) net.ListenConfig {
+	return net.ListenConfig{
+		KeepAlive: timeout - (timeout / 10),
+		Control:   nil,
+	}
+}
+
+func (s *Server) ioTimeout() time.Duration {
+	return time.Duration(s.timeout.Load())
+}
+
+func (s *Server) initListeners(ctx context.Context) error {
+	timeout, err := parseTimeout(ctx, s.Config)
+	if err != nil {
+		return err
+	}
+	s.timeout.Store(int64(timeout))
+	listenConfig := newListenConfig(timeout)
+
+	if s.Listener != nil {
+		return s.useListener(ctx)
+	}
+
+	inherited, err := processListeners()
+	if err != nil {
+		return err
+	}
+
+	for i, lc := range listenerConfigs(ctx, s.Config) {
+		name := lc.name(ctx, i)
+		file := inherited.take(name, i)
+		l, err := s.bind(ctx, listenConfig, name, lc, file)
+		if file != nil {
+			// the listener holds its own duplicate
+			file.Close()
+		}
+		if err != nil {
+			s.closeListeners()
+			s.listeners = nil
+			return fmt.Errorf("could not bind listener %s: %w", name, err)
+		}
+		s.listeners = append(s.listeners, l)
+	}
+
+	packet := isPacketNetwork(s.listeners[0].network)
+	for _, l := range s.listeners[1:] {
+		if isPacketNetwork(l.network) != packet {
+			s.closeListeners()
+			s.listeners = nil
+			return ErrMixedNetworks
+		}
+	}
+	if packet {
+		return s.initPacketOptions(ctx)
+	}
+	return nil
+}
+
+// useListener serves the injected Listener instead of binding the
+// configured addresses.
+func (s *Server) useListener(ctx context.Context) error {
+	l := &listener{
+		name:    "default",
+		network: s.Listener.Addr().Network(),
+		address: s.Listener.Addr().String(),
+		stream:  s.Listener,
+	}
+	provider, proxy, err := s.listenerOptions(ctx, listenerConfig{root: s.Config})
+	if err != nil {
+		return err
+	}
+	l.tls.Store(provider)
+	l.proxy.Store(proxy)
+	s.listeners = []*listener{l}
+	s.Logger.Info(ctx, "Listening on %s (%s)", l.Addr().String(), l.name)
+	return nil
+}
+
+// listenAddress returns the configured address of a listener, the socket
+// path for unix networks.
+func listenAddress(ctx context.Context, lc listenerConfig) string {
+	if isUnixNetwork(lc.Get(ctx, "TYPE")) {
+		if unixConfig, err := lc.GetConfig(ctx, "UNIX"); err == nil && unixConfig != nil {
+			if socketPath, _ := unixConfig.Get(ctx, "PATH"); socketPath != "" {
+				return socketPath
+			}
+		}
+		return lc.Get(ctx, "ADDRESS")
+	}
+	return fmt.Sprintf("%s:%s", lc.Get(ctx, "ADDRESS"), lc.Get(ctx, "PORT"))
+}
+
+// listenerOptions creates the TLS provider and PROXY protocol options of a
+// listener, both are nil if disabled.
+func (s *Server) listenerOptions(ctx context.Context, lc listenerConfig) (*tlsProvider, *proxyOptions, error) {
+	var provider *tlsProvider
+	if tlsConfig, err := lc.GetConfig(ctx, "TLS"); err == nil && tlsConfig != nil {
+		if provider, err = s.initTLS(ctx, tlsConfig); err != nil {
+			return nil, nil, fmt.Errorf("could not initialize tls: %w", err)
+		}
+	}
+	var proxy *proxyOptions
+	if proxyConfig, err := lc.GetConfig(ctx, "PROXYPROTOCOL"); err == nil && proxyConfig != nil {
+		if proxy, err = initProxyProtocol(ctx, proxyConfig); err != nil {
+			return nil, nil, err
+		}
+	}
+	return provider, proxy, nil
+}
+
+// bind creates the listener described by lc. If inherited is set, the
+// socket is taken over instead of binding a new one.
+func (s *Server) bind(ctx context.Context, listenConfig net.ListenConfig, name string, lc listenerConfig, inherited *os.File) (*listener, error) {
+	l := &listener{
+		name:    name,
+		network: lc.Get(ctx, "TYPE"),
+		address: listenAddress(ctx, lc),
+	}
+
+	provider, proxy, err := s.listenerOptions(ctx, lc)
+	if err != nil {
+		return nil, err
+	}
+	l.tls.Store(provider)
+	l.proxy.Store(proxy)
+
+	if inherited != nil {
+		if err := l.fromFile(inherited); err != nil {
+			return nil, fmt.Errorf("could not use inherited socket: %w", err)
+		}
+		if unixListener, ok := l.stream.(*net.UnixListener); ok {
+			l.socketPath = unixListener.Addr().String()
+		} else if unixConn, ok := l.packetConn.(*net.UnixConn); ok {
+			l.socketPath = unixConn.LocalAddr().String()
+		}
+		s.Logger.Info(ctx, "Listening on inherited %s (%s)", l.Addr().String(), l.name)
+		return l, nil
+	}
+
+	var address string
+	if isUnixNetwork(l.network) {
+		unixConfig, err := lc.GetConfig(ctx, "UNIX")
+		if err != nil {
+			return nil, fmt.Errorf("could not load unix config: %w", err)
+		}
+		if err := s.prepareUnixSocket(ctx, l, unixConfig, lc.Get(ctx, "ADDRESS")); err != nil {
+			return nil, fmt.Errorf("could not prepare unix socket: %w", err)
+		}
+		address = l.socketPath
+	} else {
+		address = l.address
+	}
+
+	if isPacketNetwork(l.network) {
+		packetConn, err := listenConfig.ListenPacket(ctx, l.network, address)
+		if err != nil {
+			return nil, err
+		}
+		l.packetConn = packetConn
+	} else {
+		stream, err := listenConfig.Listen(ctx, l.network, address)
+		if err != nil {
+			return nil, err
+		}
+		l.stream = stream
+	}
+
+	if l.socketPath != "" {
+		unixConfig, _ := lc.GetConfig(ctx, "UNIX")
+		if err := applySocketPermissions(ctx, l.socketPath, unixConfig); err != nil {
+			l.close()
+			return nil, err
+		}
+	}
+	s.Logger.Info(ctx, "Listening on %s (%s)", l.Addr().String(), l.name)
+	return l, nil
+}
+
+// Addrs returns the bound addresses of all listeners in configuration
+// order.
+func (s *Server) Addrs() []net.Addr {
+	listeners := s.currentListeners()
+	addrs := make([]net.Addr, 0, len(listeners))
+	for _, l := range listeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
+}
+
+// currentListeners returns a copy of the listeners, which Reload may
+// replace at any time.
+func (s *Server) currentListeners() []*listener {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+	return append([]*listener(nil), s.listeners...)
+}
+
+// currentConfig returns the Config, which Reload may replace at any time.
+func (s *Server) currentConfig() *config.Config {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+	return s.Config
+}
+
+// closeListeners closes all listeners and returns the first error.
+func (s *Server) closeListeners() error {
+	var firstErr error
+	for _, l := range s.currentListeners() {
+		if err := l.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,145 @@
+package base
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMultipleListeners(t *testing.T) {
+	ctx := context.Background()
+	socketPath := filepath.Join(t.TempDir(), "multi.sock")
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+		"LISTENERS": map[string]interface{}{
+			"0": map[string]interface{}{
+				"NAME": "loopback",
+			},
+			"1": map[string]interface{}{
+				"NAME": "socket",
+				"TYPE": "unix",
+				"UNIX": map[string]interface{}{
+					"PATH": socketPath,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, testHandle)
+	}()
+
+	addrs := server.Addrs()
+	if assert.Len(t, addrs, 2) {
+		assert.Equal(t, "tcp", addrs[0].Network())
+		assert.Equal(t, socketPath, addrs[1].String())
+	}
+
+	for _, addr := range addrs {
+		conn, err := net.Dial(addr.Network(), addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		buff, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, testMsg, buff)
+	}
+
+	if err := server.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, <-served)
+	for _, addr := range addrs[:1] {
+		if _, err := net.Dial(addr.Network(), addr.String()); err == nil {
+			t.Fatalf("listener %s still accepts connections after stop", addr)
+		}
+	}
+	_, err = os.Stat(socketPath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestListenRejectsMixedNetworks(t *testing.T) {
+	ctx := context.Background()
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+		"LISTENERS": map[string]interface{}{
+			"0": map[string]interface{}{
+				"TYPE": "tcp",
+			},
+			"1": map[string]interface{}{
+				"TYPE": "udp",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	assert.ErrorIs(t, server.Listen(ctx, conf), ErrMixedNetworks)
+	assert.Empty(t, server.Addrs())
+}
+
+func TestListenersInheritTLS(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ca := generateTestCert(t, "test-ca", nil, true)
+	certFile, keyFile := generateTestCert(t, "server", ca, false).write(t, dir, "server")
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+		"TLS": map[string]interface{}{
+			"ENABLED": true,
+			"CERT":    certFile,
+			"KEY":     keyFile,
+		},
+		"LISTENERS": map[string]interface{}{
+			"0": map[string]interface{}{
+				"NAME": "inherited",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ctx, testHandle)
+	t.Cleanup(func() {
+		server.Stop(ctx)
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", server.Addrs()[0].String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buff, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testMsg, buff)
+}
@@ -0,0 +1,73 @@
+package base
+
+import (
+	"context"
+	"time"
+
+	"github.com/myLogic207/pepper/metrics"
+)
+
+// serverMetrics are the instruments of a server. All of them are nil if
+// Server.Metrics is not set.
+type serverMetrics struct {
+	name     string
+	accepted *metrics.CounterVec
+	rejected *metrics.CounterVec
+	failed   *metrics.CounterVec
+	active   *metrics.Gauge
+	duration *metrics.Histogram
+	bytesIn  *metrics.Counter
+	bytesOut *metrics.Counter
+}
+
+func (s *Server) initMetrics(ctx context.Context) error {
+	name := "base"
+	if metricsConfig, err := s.Config.GetConfig(ctx, "METRICS"); err == nil && metricsConfig != nil {
+		if configured, _ := metricsConfig.Get(ctx, "NAME"); configured != "" {
+			name = configured
+		}
+	}
+	s.metrics = serverMetrics{name: name}
+	registry := s.Metrics
+
+	var err error
+	if s.metrics.accepted, err = registry.CounterVec("pepper_connections_accepted_total", "Connections accepted per listener.", "server", "listener"); err != nil {
+		return err
+	}
+	if s.metrics.rejected, err = registry.CounterVec("pepper_connections_rejected_total", "Connections refused per reason.", "server", "reason"); err != nil {
+		return err
+	}
+	if s.metrics.failed, err = registry.CounterVec("pepper_accept_errors_total", "Failed accepts per listener and error class.", "server", "listener", "class"); err != nil {
+		return err
+	}
+	active, err := registry.GaugeVec("pepper_connections_active", "Connections currently served or waiting for a worker.", "server")
+	if err != nil {
+		return err
+	}
+	s.metrics.active = active.With(name)
+	queued, err := registry.GaugeVec("pepper_connections_queued", "Connections waiting for a worker.", "server")
+	if err != nil {
+		return err
+	}
+	queued.Func(func() float64 {
+		return float64(s.limiter.snapshot().QueueDepth)
+	}, name)
+	duration, err := registry.HistogramVec("pepper_handler_duration_seconds", "Time connections were served.", nil, "server")
+	if err != nil {
+		return err
+	}
+	s.metrics.duration = duration.With(name)
+	bytes, err := registry.CounterVec("pepper_connection_bytes_total", "Bytes transferred by closed connections.", "server", "direction")
+	if err != nil {
+		return err
+	}
+	s.metrics.bytesIn = bytes.With(name, "in")
+	s.metrics.bytesOut = bytes.With(name, "out")
+	return nil
+}
+
+func (m *serverMetrics) connectionClosed(entry *connEntry, duration time.Duration) {
+	m.duration.Observe(duration.Seconds())
+	m.bytesIn.Add(float64(entry.bytes.Read()))
+	m.bytesOut.Add(float64(entry.bytes.Written()))
+}
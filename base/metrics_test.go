@@ -0,0 +1,42 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/pepper/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	closed := make(chan struct{}, 1)
+	server := startHookServer(t, map[string]interface{}{}, func(s *Server) {
+		s.Metrics = registry
+		s.OnClose = func(ctx context.Context, info ConnectionInfo, duration time.Duration, err error) {
+			closed <- struct{}{}
+		}
+	}, testHandle)
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(conn)
+	conn.Close()
+	<-closed
+
+	buff := &bytes.Buffer{}
+	if _, err := registry.WriteTo(buff); err != nil {
+		t.Fatal(err)
+	}
+	exposed := buff.String()
+	assert.Contains(t, exposed, `pepper_connections_accepted_total{server="base",listener="default"} 1`)
+	assert.Contains(t, exposed, `pepper_handler_duration_seconds_count{server="base"} 1`)
+	assert.Contains(t, exposed, fmt.Sprintf(`pepper_connection_bytes_total{server="base",direction="out"} %d`, len(testMsg)))
+}
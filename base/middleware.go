@@ -0,0 +1,167 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	log "github.com/myLogic207/gotils/logger"
+)
+
+// ErrHandlerPanic indicates that a handler panicked and was recovered.
+var ErrHandlerPanic = errors.New("handler panicked")
+
+const contextKeyByteCounter = contextKey("byte-counter")
+
+// Middleware wraps a HandleFunc to add behavior around it.
+type Middleware func(HandleFunc) HandleFunc
+
+// Use registers middlewares that wrap the handler passed to Serve. The first
+// registered middleware is the outermost one. Use must be called before
+// Serve.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// Chain wraps handle with the given middlewares, the first middleware is the
+// outermost one.
+func Chain(handle HandleFunc, middlewares ...Middleware) HandleFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handle = middlewares[i](handle)
+	}
+	return handle
+}
+
+// Recover recovers panics of the wrapped handler. The stack is logged, the
+// connection is closed and ErrHandlerPanic is returned.
+func Recover(logger log.Logger) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, conn net.Conn) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error(ctx, "Recovered handler panic: %v\n%s", r, debug.Stack())
+					conn.Close()
+					err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+				}
+			}()
+			return next(ctx, conn)
+		}
+	}
+}
+
+// idleConn extends the read and write deadline before every operation, so
+// the connection times out once it is idle for the given duration.
+type idleConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	if c.readTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+// IdleTimeout closes connections that do not complete a read or write within
+// the given durations. A zero duration disables the respective timeout.
+func IdleTimeout(read, write time.Duration) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, conn net.Conn) error {
+			return next(ctx, &idleConn{Conn: conn, readTimeout: read, writeTimeout: write})
+		}
+	}
+}
+
+// ByteCounter counts the bytes transferred over a connection.
+type ByteCounter struct {
+	read    atomic.Uint64
+	written atomic.Uint64
+}
+
+// Read returns the number of bytes received from the peer.
+func (bc *ByteCounter) Read() uint64 {
+	return bc.read.Load()
+}
+
+// Written returns the number of bytes sent to the peer.
+func (bc *ByteCounter) Written() uint64 {
+	return bc.written.Load()
+}
+
+type countingConn struct {
+	net.Conn
+	counter *ByteCounter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.counter.read.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counter.written.Add(uint64(n))
+	return n, err
+}
+
+// BytesFromContext returns the byte counter of the handled connection. It is
+// available for every connection served by Server; handlers used elsewhere
+// need the CountBytes or AccessLog middleware.
+func BytesFromContext(ctx context.Context) (*ByteCounter, bool) {
+	counter, ok := ctx.Value(contextKeyByteCounter).(*ByteCounter)
+	return counter, ok
+}
+
+// CountBytes counts the bytes read and written by the wrapped handler. The
+// counter is available through BytesFromContext. Connections that are
+// already counted are passed through unchanged.
+func CountBytes() Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, conn net.Conn) error {
+			if _, ok := BytesFromContext(ctx); ok {
+				return next(ctx, conn)
+			}
+			counter := &ByteCounter{}
+			ctx = context.WithValue(ctx, contextKeyByteCounter, counter)
+			return next(ctx, &countingConn{Conn: conn, counter: counter})
+		}
+	}
+}
+
+// AccessLog logs every connection once the wrapped handler returns, with the
+// duration and the number of bytes transferred.
+func AccessLog(logger log.Logger) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return CountBytes()(func(ctx context.Context, conn net.Conn) error {
+			start := time.Now()
+			err := next(ctx, conn)
+			counter, _ := BytesFromContext(ctx)
+			status := "ok"
+			if err != nil {
+				status = err.Error()
+			}
+			logger.Info(ctx, "remote=%s local=%s duration=%s in=%d out=%d status=%q",
+				conn.RemoteAddr().String(), conn.LocalAddr().String(), time.Since(start).String(),
+				counter.Read(), counter.Written(), status)
+			return err
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package base
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func startMiddlewareServer(t *testing.T, handle HandleFunc, middlewares func(*Server) []Middleware) *Server {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	server.Use(middlewares(server)...)
+	go func() {
+		if err := server.Serve(ctx, handle); err != nil {
+			panic(err)
+		}
+	}()
+	return server
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next HandleFunc) HandleFunc {
+			return func(ctx context.Context, conn net.Conn) error {
+				order = append(order, name)
+				return next(ctx, conn)
+			}
+		}
+	}
+	handle := Chain(func(ctx context.Context, conn net.Conn) error {
+		order = append(order, "handler")
+		return nil
+	}, trace("first"), trace("second"))
+
+	assert.NoError(t, handle(context.Background(), nil))
+	assert.Equal(t, []string{"first", "second", "handler"}, order)
+}
+
+func TestRecover(t *testing.T) {
+	errs := make(chan error, 1)
+	server := startMiddlewareServer(t, func(ctx context.Context, conn net.Conn) error {
+		panic("boom")
+	}, func(s *Server) []Middleware {
+		report := func(next HandleFunc) HandleFunc {
+			return func(ctx context.Context, conn net.Conn) error {
+				errs <- next(ctx, conn)
+				return nil
+			}
+		}
+		return []Middleware{report, Recover(s.Logger)}
+	})
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", server.Addrs()[0].String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		buff, err := io.ReadAll(conn)
+		conn.Close()
+		assert.NoError(t, err)
+		assert.Empty(t, buff)
+		assert.ErrorIs(t, <-errs, ErrHandlerPanic)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	server := startMiddlewareServer(t, func(ctx context.Context, conn net.Conn) error {
+		_, err := conn.Read(make([]byte, 1))
+		errs <- err
+		return nil
+	}, func(*Server) []Middleware {
+		return []Middleware{IdleTimeout(50*time.Millisecond, 0)}
+	})
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not timed out")
+	}
+}
+
+func TestCountBytes(t *testing.T) {
+	counts := make(chan [2]uint64, 1)
+	server := startMiddlewareServer(t, func(ctx context.Context, conn net.Conn) error {
+		counter, ok := BytesFromContext(ctx)
+		if !ok {
+			counts <- [2]uint64{}
+			return nil
+		}
+		if _, err := conn.Write(testMsg); err != nil {
+			return err
+		}
+		if _, err := io.ReadAll(conn); err != nil {
+			return err
+		}
+		counts <- [2]uint64{counter.Read(), counter.Written()}
+		return nil
+	}, func(*Server) []Middleware {
+		return []Middleware{CountBytes()}
+	})
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	assert.Equal(t, [2]uint64{4, uint64(len(testMsg))}, <-counts)
+}
@@ -0,0 +1,232 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// ErrNoRoute indicates that no route matched a connection and the mux has
+// no fallback handler.
+var ErrNoRoute = errors.New("no protocol matched the connection")
+
+const (
+	// DefaultSniffTimeout is used if Mux.SniffTimeout is not set.
+	DefaultSniffTimeout = 2 * time.Second
+	// DefaultMaxPeek is used if Mux.MaxPeek is not set.
+	DefaultMaxPeek = 64
+)
+
+// Match is the verdict of a Matcher on the first bytes of a connection.
+type Match int
+
+const (
+	// NoMatch means the bytes do not belong to the protocol.
+	NoMatch Match = iota
+	// NeedMore means the bytes are a valid start but more are needed to
+	// decide.
+	NeedMore
+	// Matched means the connection speaks the protocol.
+	Matched
+)
+
+// Matcher inspects the first bytes of a connection. It is called again with
+// a longer slice while it returns NeedMore.
+type Matcher func(peeked []byte) Match
+
+// PrefixMatcher matches connections starting with prefix.
+func PrefixMatcher(prefix string) Matcher {
+	return func(peeked []byte) Match {
+		if len(peeked) < len(prefix) {
+			if bytes.HasPrefix([]byte(prefix), peeked) {
+				return NeedMore
+			}
+			return NoMatch
+		}
+		if bytes.HasPrefix(peeked, []byte(prefix)) {
+			return Matched
+		}
+		return NoMatch
+	}
+}
+
+// AnyMatcher matches if any of the given matchers does.
+func AnyMatcher(matchers ...Matcher) Matcher {
+	return func(peeked []byte) Match {
+		verdict := NoMatch
+		for _, match := range matchers {
+			switch match(peeked) {
+			case Matched:
+				return Matched
+			case NeedMore:
+				verdict = NeedMore
+			}
+		}
+		return verdict
+	}
+}
+
+// SSHMatcher matches the SSH identification string a client sends first.
+var SSHMatcher = PrefixMatcher("SSH-")
+
+// TLSMatcher matches a TLS record containing a ClientHello.
+func TLSMatcher(peeked []byte) Match {
+	// content type handshake, major version 3, minor version up to TLS 1.3,
+	// two bytes record length and the handshake type ClientHello
+	header := []byte{0x16, 0x03}
+	for i, b := range peeked {
+		switch {
+		case i < len(header) && b != header[i]:
+			return NoMatch
+		case i == 2 && b > 0x04:
+			return NoMatch
+		case i == 5:
+			if b != 0x01 {
+				return NoMatch
+			}
+			return Matched
+		}
+	}
+	return NeedMore
+}
+
+// HTTPMatcher matches HTTP/1.x requests and the HTTP/2 connection preface.
+var HTTPMatcher = AnyMatcher(
+	PrefixMatcher("GET "),
+	PrefixMatcher("HEAD "),
+	PrefixMatcher("POST "),
+	PrefixMatcher("PUT "),
+	PrefixMatcher("DELETE "),
+	PrefixMatcher("CONNECT "),
+	PrefixMatcher("OPTIONS "),
+	PrefixMatcher("TRACE "),
+	PrefixMatcher("PATCH "),
+	PrefixMatcher("PRI * HTTP/2.0"),
+)
+
+type route struct {
+	name   string
+	match  Matcher
+	handle HandleFunc
+}
+
+// Mux routes connections to handlers based on their first bytes, so
+// multiple protocols can be served on one port. Its Serve method is a
+// HandleFunc to be passed to Server.Serve. Routes are registered with Handle
+// before serving and are tried in order.
+//
+// Routes see the raw stream, a listener with TLS configured terminates TLS
+// before the mux. To serve TLS next to other protocols, leave TLS off the
+// listener and wrap the connection with tls.Server in the TLS route.
+type Mux struct {
+	// Fallback handles connections that match no route, including clients
+	// that wait for the server to speak first and send nothing within the
+	// sniff timeout.
+	Fallback HandleFunc
+	// SniffTimeout bounds the time spent waiting for the first bytes.
+	SniffTimeout time.Duration
+	// MaxPeek is the number of bytes after which sniffing gives up.
+	MaxPeek int
+	routes  []route
+}
+
+// Handle registers a route. name is used for logging and shown as the
+// "protocol" annotation in Server.Connections.
+func (m *Mux) Handle(name string, match Matcher, handle HandleFunc) {
+	m.routes = append(m.routes, route{name: name, match: match, handle: handle})
+}
+
+// match returns the first route that matched while all routes before it
+// ruled the bytes out. more is set if a decision needs more bytes.
+func (m *Mux) match(peeked []byte) (r *route, more bool) {
+	for i := range m.routes {
+		switch m.routes[i].match(peeked) {
+		case Matched:
+			if !more {
+				return &m.routes[i], false
+			}
+		case NeedMore:
+			more = true
+		}
+	}
+	return nil, more
+}
+
+// Serve sniffs the protocol of conn and hands it to the matching route. The
+// peeked bytes are preserved, the handler reads the stream from the start.
+func (m *Mux) Serve(ctx context.Context, conn net.Conn) error {
+	timeout := m.SniffTimeout
+	if timeout <= 0 {
+		timeout = DefaultSniffTimeout
+	}
+	maxPeek := m.MaxPeek
+	if maxPeek <= 0 {
+		maxPeek = DefaultMaxPeek
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(conn, maxPeek)
+	sniffed := &sniffedConn{Conn: conn, reader: reader}
+	selected, err := m.sniff(reader, maxPeek)
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("could not sniff protocol: %w", err)
+	}
+	if errors.Is(err, io.EOF) && reader.Buffered() == 0 {
+		// the client closed the connection without sending anything
+		return nil
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	if selected == nil {
+		if m.Fallback == nil {
+			return ErrNoRoute
+		}
+		Annotate(ctx, "protocol", "fallback")
+		return m.Fallback(ctx, sniffed)
+	}
+	Annotate(ctx, "protocol", selected.name)
+	return selected.handle(ctx, sniffed)
+}
+
+// sniff peeks at growing prefixes of the stream until a route matches, all
+// routes rule it out, maxPeek bytes were read or reading fails.
+func (m *Mux) sniff(reader *bufio.Reader, maxPeek int) (*route, error) {
+	need := 1
+	for {
+		_, err := reader.Peek(need)
+		// look at everything that arrived, which does not block
+		peeked, _ := reader.Peek(reader.Buffered())
+		selected, more := m.match(peeked)
+		if selected != nil || !more {
+			return selected, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		need = len(peeked) + 1
+		if need > maxPeek {
+			return nil, nil
+		}
+	}
+}
+
+// sniffedConn reads through the buffered reader the protocol was sniffed
+// from.
+type sniffedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,144 @@
+package base
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchers(t *testing.T) {
+	cases := []struct {
+		name    string
+		matcher Matcher
+		input   string
+		want    Match
+	}{
+		{"ssh", SSHMatcher, "SSH-2.0-OpenSSH_9.6\r\n", Matched},
+		{"ssh partial", SSHMatcher, "SS", NeedMore},
+		{"ssh mismatch", SSHMatcher, "GET /", NoMatch},
+		{"tls", TLSMatcher, "\x16\x03\x01\x02\x00\x01\x00", Matched},
+		{"tls partial", TLSMatcher, "\x16\x03", NeedMore},
+		{"tls not client hello", TLSMatcher, "\x16\x03\x01\x02\x00\x02", NoMatch},
+		{"tls bad version", TLSMatcher, "\x16\x03\x09", NoMatch},
+		{"http get", HTTPMatcher, "GET /health HTTP/1.1\r\n", Matched},
+		{"http partial", HTTPMatcher, "OPT", NeedMore},
+		{"http h2 preface", HTTPMatcher, "PRI * HTTP/2.0\r\n", Matched},
+		{"http mismatch", HTTPMatcher, "GETX", NoMatch},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, c.matcher([]byte(c.input)), c.name)
+	}
+}
+
+// echoRoute replies with the route name followed by the first line read
+// from the connection.
+func echoRoute(name string) HandleFunc {
+	return func(ctx context.Context, conn net.Conn) error {
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		_, err = conn.Write([]byte(name + ":" + line))
+		return err
+	}
+}
+
+func startMuxServer(t *testing.T) *Server {
+	t.Helper()
+	mux := &Mux{SniffTimeout: 200 * time.Millisecond}
+	mux.Handle("ssh", SSHMatcher, echoRoute("ssh"))
+	mux.Handle("tls", TLSMatcher, echoRoute("tls"))
+	mux.Handle("http", HTTPMatcher, echoRoute("http"))
+	mux.Fallback = func(ctx context.Context, conn net.Conn) error {
+		// like an SSH server, greet clients that wait for the server
+		if _, err := conn.Write([]byte("hello\n")); err != nil {
+			return err
+		}
+		return echoRoute("fallback")(ctx, conn)
+	}
+	server, _ := startTestServer(t, mux.Serve)
+	t.Cleanup(func() {
+		server.Shutdown(context.Background())
+	})
+	return server
+}
+
+func muxExchange(t *testing.T, addr string, send string, delay time.Duration) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// write in two parts, so sniffing has to wait for more bytes
+	if _, err := conn.Write([]byte(send[:2])); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(delay)
+	if _, err := conn.Write([]byte(send[2:])); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(reply)
+}
+
+func TestMuxRoutes(t *testing.T) {
+	server := startMuxServer(t)
+	addr := server.Addrs()[0].String()
+
+	assert.Equal(t, "ssh:SSH-2.0-client\r\n", muxExchange(t, addr, "SSH-2.0-client\r\n", 20*time.Millisecond))
+	assert.Equal(t, "http:GET /health HTTP/1.1\r\n", muxExchange(t, addr, "GET /health HTTP/1.1\r\n", 20*time.Millisecond))
+	tlsHello := "\x16\x03\x01\x00\x05\x01\x00\x00\x01\x00\n"
+	assert.Equal(t, "tls:"+tlsHello, muxExchange(t, addr, tlsHello, 20*time.Millisecond))
+	assert.Equal(t, "hello\nfallback:unknown\n", muxExchange(t, addr, "unknown\n", 0))
+}
+
+func TestMuxFallbackOnSilence(t *testing.T) {
+	server := startMuxServer(t)
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	greeting, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hello\n", greeting)
+	infos := server.Connections()
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, "fallback", infos[0].Annotations["protocol"])
+	}
+	// the deadline used for sniffing must not apply to the handler
+	time.Sleep(300 * time.Millisecond)
+	if _, err := conn.Write([]byte("late\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "fallback:late\n", reply)
+}
+
+func TestMuxNoRoute(t *testing.T) {
+	mux := &Mux{}
+	mux.Handle("ssh", SSHMatcher, echoRoute("ssh"))
+	client, remote := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("GET / HTTP/1.1\r\n"))
+	err := mux.Serve(context.Background(), remote)
+	assert.ErrorIs(t, err, ErrNoRoute)
+}
@@ -0,0 +1,288 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+)
+
+var (
+	ErrOverloadConfig = errors.New("invalid overload config")
+	ErrMaxConnections = errors.New("would exceed max connections")
+	ErrQueueFull      = errors.New("waiting queue is full")
+	ErrQueueTimeout   = errors.New("timed out waiting for a free worker")
+)
+
+// goodbyeTimeout bounds the time spent writing a goodbye to a rejected
+// connection.
+const goodbyeTimeout = time.Second
+
+const (
+	// OverloadReject closes connections immediately once MAXCONN handlers
+	// are running.
+	OverloadReject = "reject"
+	// OverloadQueue parks connections in a bounded queue until a handler
+	// returns or OVERLOAD.QUEUETIMEOUT expires.
+	OverloadQueue = "queue"
+)
+
+// GoodbyeFunc writes a protocol specific message to a connection that is
+// rejected because the server is overloaded. It is called before any TLS
+// handshake or PROXY protocol header.
+type GoodbyeFunc func(ctx context.Context, conn net.Conn) error
+
+// OverloadStats describes the worker pool and its waiting queue.
+type OverloadStats struct {
+	// Active is the number of running handlers.
+	Active int
+	// QueueDepth is the number of connections currently waiting.
+	QueueDepth int
+	// Queued is the number of connections that had to wait for a worker.
+	Queued uint64
+	// TimedOut is the number of queued connections that were rejected
+	// after OVERLOAD.QUEUETIMEOUT.
+	TimedOut uint64
+	// WaitTime is the total time queued connections waited.
+	WaitTime time.Duration
+	// MaxWait is the longest time a connection waited.
+	MaxWait time.Duration
+}
+
+type overloadOptions struct {
+	limit        int
+	queueSize    int
+	policy       string
+	message      []byte
+	queueTimeout time.Duration
+}
+
+type waiter struct {
+	ready chan struct{}
+	since time.Time
+}
+
+// connLimiter limits the number of running handlers. Connections that
+// exceed the limit may wait in a FIFO queue, a freed slot is handed over to
+// the first waiter directly. A negative limit disables the limiter.
+type connLimiter struct {
+	sync.Mutex
+	limit     int
+	active    int
+	queueSize int
+	queue     []*waiter
+	stats     OverloadStats
+}
+
+func (cl *connLimiter) tryAcquire() bool {
+	cl.Lock()
+	defer cl.Unlock()
+	if cl.limit >= 0 && (cl.active >= cl.limit || len(cl.queue) > 0) {
+		return false
+	}
+	cl.active++
+	return true
+}
+
+// enqueue reserves a place in the queue, it fails if the queue is full.
+func (cl *connLimiter) enqueue() (*waiter, bool) {
+	cl.Lock()
+	defer cl.Unlock()
+	if len(cl.queue) >= cl.queueSize {
+		return nil, false
+	}
+	w := &waiter{ready: make(chan struct{}), since: time.Now()}
+	cl.queue = append(cl.queue, w)
+	cl.stats.Queued++
+	return w, true
+}
+
+// wait blocks until w is handed a slot, the timeout expires or ctx is done.
+// If nil is returned, the caller owns a slot and must release it.
+func (cl *connLimiter) wait(ctx context.Context, w *waiter, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	cl.Lock()
+	defer cl.Unlock()
+	waited := time.Since(w.since)
+	cl.stats.WaitTime += waited
+	cl.stats.MaxWait = max(cl.stats.MaxWait, waited)
+	for i, queued := range cl.queue {
+		if queued == w {
+			cl.queue = append(cl.queue[:i], cl.queue[i+1:]...)
+			if errors.Is(err, ErrQueueTimeout) {
+				cl.stats.TimedOut++
+			}
+			return err
+		}
+	}
+	// the slot was handed over while giving up
+	if err != nil && !errors.Is(err, ErrQueueTimeout) {
+		cl.releaseLocked()
+		return err
+	}
+	return nil
+}
+
+func (cl *connLimiter) release() {
+	cl.Lock()
+	cl.releaseLocked()
+	cl.Unlock()
+}
+
+func (cl *connLimiter) releaseLocked() {
+	if len(cl.queue) > 0 && (cl.limit < 0 || cl.active <= cl.limit) {
+		w := cl.queue[0]
+		cl.queue = cl.queue[1:]
+		close(w.ready)
+		return
+	}
+	cl.active--
+}
+
+// resize changes the limit and the queue size. Slots freed by a larger limit
+// are handed to waiters, waiters beyond a smaller queue keep waiting.
+func (cl *connLimiter) resize(limit, queueSize int) {
+	cl.Lock()
+	defer cl.Unlock()
+	cl.limit = limit
+	cl.queueSize = queueSize
+	for len(cl.queue) > 0 && (cl.limit < 0 || cl.active < cl.limit) {
+		w := cl.queue[0]
+		cl.queue = cl.queue[1:]
+		cl.active++
+		close(w.ready)
+	}
+}
+
+func (cl *connLimiter) snapshot() OverloadStats {
+	cl.Lock()
+	defer cl.Unlock()
+	stats := cl.stats
+	stats.Active = cl.active
+	stats.QueueDepth = len(cl.queue)
+	return stats
+}
+
+// parseOverload reads MAXCONN and the OVERLOAD config.
+func parseOverload(ctx context.Context, conf *config.Config) (*overloadOptions, error) {
+	maxconn, _ := conf.Get(ctx, "MAXCONN")
+	limit, err := strconv.Atoi(maxconn)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse max connections: %s", maxconn)
+	}
+	options := &overloadOptions{limit: limit, policy: OverloadReject}
+
+	overloadConfig, err := conf.GetConfig(ctx, "OVERLOAD")
+	if err != nil {
+		return options, nil
+	}
+	if policy, err := overloadConfig.Get(ctx, "POLICY"); err == nil && policy != "" {
+		options.policy = policy
+	}
+	switch options.policy {
+	case OverloadReject:
+	case OverloadQueue:
+		queueSize, _ := overloadConfig.Get(ctx, "QUEUESIZE")
+		if options.queueSize, err = strconv.Atoi(queueSize); err != nil || options.queueSize < 0 {
+			return nil, fmt.Errorf("%w: could not parse queue size: %s", ErrOverloadConfig, queueSize)
+		}
+		queueTimeout, _ := overloadConfig.Get(ctx, "QUEUETIMEOUT")
+		if options.queueTimeout, err = time.ParseDuration(queueTimeout); err != nil {
+			return nil, fmt.Errorf("%w: could not parse queue timeout: %s", ErrOverloadConfig, queueTimeout)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown policy %s", ErrOverloadConfig, options.policy)
+	}
+	if message, err := overloadConfig.Get(ctx, "MESSAGE"); err == nil {
+		options.message = []byte(message)
+	}
+	return options, nil
+}
+
+func (s *Server) initOverload(ctx context.Context) error {
+	options, err := parseOverload(ctx, s.Config)
+	if err != nil {
+		return err
+	}
+	s.limiter = &connLimiter{limit: options.limit, queueSize: options.queueSize}
+	s.overload.Store(options)
+	return nil
+}
+
+// OverloadStats returns the current state of the worker pool and its
+// waiting queue.
+func (s *Server) OverloadStats() OverloadStats {
+	return s.limiter.snapshot()
+}
+
+// acquireWorker reserves a worker according to the overload policy. If nil
+// is returned, the worker must be released with s.limiter.release.
+func (s *Server) acquireWorker(ctx context.Context) (RejectReason, error) {
+	if s.limiter.tryAcquire() {
+		return "", nil
+	}
+	options := s.overload.Load()
+	if options.policy != OverloadQueue {
+		return RejectMaxConn, ErrMaxConnections
+	}
+	w, ok := s.limiter.enqueue()
+	if !ok {
+		return RejectMaxConn, ErrQueueFull
+	}
+	// queued connections are not tracked yet, Shutdown ends their wait
+	// through quit
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := s.limiter.wait(ctx, w, options.queueTimeout); err != nil {
+		return RejectQueueTimeout, err
+	}
+	return "", nil
+}
+
+// rejectOverloaded says goodbye to and closes a connection that did not get
+// a worker.
+func (s *Server) rejectOverloaded(ctx context.Context, conn net.Conn, reason RejectReason, cause error) {
+	defer conn.Close()
+	s.acl.count(reason)
+	s.Logger.Info(ctx, "Rejected connection from %s: %s", conn.RemoteAddr().String(), cause.Error())
+	s.onReject(ctx, conn.RemoteAddr(), reason)
+
+	goodbye := s.Goodbye
+	if message := s.overload.Load().message; goodbye == nil && len(message) > 0 {
+		goodbye = func(ctx context.Context, conn net.Conn) error {
+			_, err := conn.Write(message)
+			return err
+		}
+	}
+	if goodbye == nil || errors.Is(cause, context.Canceled) {
+		return
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(goodbyeTimeout)); err != nil {
+		return
+	}
+	if err := goodbye(ctx, conn); err != nil {
+		s.Logger.Debug(ctx, "Could not send goodbye to %s: %s", conn.RemoteAddr().String(), err.Error())
+	}
+}
@@ -0,0 +1,214 @@
+package base
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func startOverloadServer(t *testing.T, overload map[string]interface{}, handle HandleFunc) *Server {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"ADDRESS":  tADDRESS,
+		"PORT":     tPORT,
+		"MAXCONN":  1,
+		"OVERLOAD": overload,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := server.Serve(ctx, handle); err != nil {
+			panic(err)
+		}
+	}()
+	return server
+}
+
+// blockingHandle blocks the first connection until unblock is closed, all
+// other connections are served by testHandle.
+func blockingHandle() (handle HandleFunc, started <-chan struct{}, unblock chan struct{}) {
+	startedCh := make(chan struct{})
+	unblock = make(chan struct{})
+	first := true
+	return func(ctx context.Context, conn net.Conn) error {
+		if first {
+			first = false
+			close(startedCh)
+			<-unblock
+			return nil
+		}
+		return testHandle(ctx, conn)
+	}, startedCh, unblock
+}
+
+func dialAndRead(t *testing.T, addr string) []byte {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buff, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buff
+}
+
+func TestOverloadReject(t *testing.T) {
+	handle, started, unblock := blockingHandle()
+	defer close(unblock)
+	server := startOverloadServer(t, map[string]interface{}{
+		"POLICY":  OverloadReject,
+		"MESSAGE": "busy\n",
+	}, handle)
+	addr := server.Addrs()[0].String()
+
+	blocked, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocked.Close()
+	<-started
+
+	assert.Equal(t, []byte("busy\n"), dialAndRead(t, addr))
+	assert.Equal(t, uint64(1), server.Rejections()[RejectMaxConn])
+}
+
+func TestOverloadRejectNotTracked(t *testing.T) {
+	handle, started, unblock := blockingHandle()
+	defer close(unblock)
+	var accepted atomic.Int32
+	server := startHookServer(t, map[string]interface{}{
+		"MAXCONN": 1,
+		"OVERLOAD": map[string]interface{}{
+			"POLICY": OverloadReject,
+		},
+	}, func(s *Server) {
+		s.OnAccept = func(ctx context.Context, conn net.Conn) error {
+			accepted.Add(1)
+			return nil
+		}
+	}, handle)
+	addr := server.Addrs()[0].String()
+
+	blocked, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocked.Close()
+	<-started
+
+	assert.Empty(t, dialAndRead(t, addr))
+	assert.Equal(t, uint64(1), server.Rejections()[RejectMaxConn])
+	assert.Equal(t, int32(1), accepted.Load())
+	assert.Len(t, server.Connections(), 1)
+}
+
+func TestOverloadQueue(t *testing.T) {
+	handle, started, unblock := blockingHandle()
+	server := startOverloadServer(t, map[string]interface{}{
+		"POLICY":       OverloadQueue,
+		"QUEUESIZE":    1,
+		"QUEUETIMEOUT": "5s",
+	}, handle)
+	addr := server.Addrs()[0].String()
+
+	blocked, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocked.Close()
+	<-started
+
+	go func() {
+		for server.OverloadStats().QueueDepth == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		close(unblock)
+	}()
+	assert.Equal(t, testMsg, dialAndRead(t, addr))
+
+	stats := server.OverloadStats()
+	assert.Equal(t, uint64(1), stats.Queued)
+	assert.Equal(t, uint64(0), stats.TimedOut)
+	assert.Equal(t, 0, stats.QueueDepth)
+	assert.Greater(t, stats.MaxWait, time.Duration(0))
+}
+
+func TestOverloadQueueTimeout(t *testing.T) {
+	handle, started, unblock := blockingHandle()
+	defer close(unblock)
+	server := startOverloadServer(t, map[string]interface{}{
+		"POLICY":       OverloadQueue,
+		"QUEUESIZE":    1,
+		"QUEUETIMEOUT": "50ms",
+		"MESSAGE":      "busy\n",
+	}, handle)
+	addr := server.Addrs()[0].String()
+
+	blocked, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocked.Close()
+	<-started
+
+	assert.Equal(t, []byte("busy\n"), dialAndRead(t, addr))
+	stats := server.OverloadStats()
+	assert.Equal(t, uint64(1), stats.Queued)
+	assert.Equal(t, uint64(1), stats.TimedOut)
+	assert.Equal(t, uint64(1), server.Rejections()[RejectQueueTimeout])
+}
+
+func TestOverloadQueueShutdown(t *testing.T) {
+	handle, started, unblock := blockingHandle()
+	defer close(unblock)
+	server := startOverloadServer(t, map[string]interface{}{
+		"POLICY":       OverloadQueue,
+		"QUEUESIZE":    1,
+		"QUEUETIMEOUT": "5s",
+	}, handle)
+	addr := server.Addrs()[0].String()
+
+	blocked, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocked.Close()
+	<-started
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	queued := make(chan []byte)
+	go func() {
+		buff, _ := io.ReadAll(conn)
+		queued <- buff
+	}()
+	for server.OverloadStats().QueueDepth == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	server.Shutdown(ctx)
+
+	// the queued connection is closed without waiting for the timeout
+	assert.Empty(t, <-queued)
+	assert.Equal(t, uint64(0), server.OverloadStats().TimedOut)
+}
@@ -0,0 +1,295 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	// ErrNotStreamListener indicates that Serve was called on a server
+	// listening on a packet network.
+	ErrNotStreamListener = errors.New("server is not listening on a stream network")
+
+	// ErrNotPacketListener indicates that ServePacket was called on a server
+	// listening on a stream network.
+	ErrNotPacketListener = errors.New("server is not listening on a packet network")
+)
+
+const contextKeyPacketSession = contextKey("packet-session")
+
+// ReplyFunc sends a datagram back to the peer a packet was received from.
+type ReplyFunc func(data []byte) error
+
+// PacketHandleFunc is called for every datagram received by the server.
+// The data slice is owned by the handler.
+type PacketHandleFunc func(ctx context.Context, addr net.Addr, data []byte, reply ReplyFunc) error
+
+type packetOptions struct {
+	size           int
+	sessions       bool
+	sessionTimeout time.Duration
+}
+
+func isPacketNetwork(network string) bool {
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) initPacketOptions(ctx context.Context) error {
+	packetConfig, err := s.Config.GetConfig(ctx, "PACKET")
+	if err != nil {
+		return fmt.Errorf("could not load packet config: %w", err)
+	}
+	sizeRaw, _ := packetConfig.Get(ctx, "SIZE")
+	size, err := strconv.Atoi(sizeRaw)
+	if err != nil || size <= 0 {
+		return fmt.Errorf("could not parse packet size: %s", sizeRaw)
+	}
+	sessionsRaw, _ := packetConfig.Get(ctx, "SESSIONS")
+	sessions, _ := strconv.ParseBool(sessionsRaw)
+	timeoutRaw, _ := packetConfig.Get(ctx, "SESSIONTIMEOUT")
+	sessionTimeout, err := time.ParseDuration(timeoutRaw)
+	if err != nil {
+		return fmt.Errorf("could not parse session timeout: %w", err)
+	}
+	s.packet = packetOptions{
+		size:           size,
+		sessions:       sessions,
+		sessionTimeout: sessionTimeout,
+	}
+	return nil
+}
+
+// ServePacket serves the packet oriented listeners (udp, unixgram). Every
+// received datagram is handled by a worker; at most MAXCONN workers run at
+// the same time across all listeners, further datagrams wait for a free
+// worker.
+func (s *Server) ServePacket(ctx context.Context, handle PacketHandleFunc) error {
+	listeners := s.currentListeners()
+	if len(listeners) == 0 || listeners[0].packetConn == nil {
+		return ErrNotPacketListener
+	}
+	maxconn, _ := s.currentConfig().Get(ctx, "MAXCONN")
+	max, err := strconv.Atoi(maxconn)
+	if err != nil {
+		return fmt.Errorf("could not parse max connections: %s", maxconn)
+	}
+	group, eCtx := errgroup.WithContext(ctx)
+	workers := &errgroup.Group{}
+	workers.SetLimit(max)
+	handlerCtx, cancelHandlers := context.WithCancel(ctx)
+	defer cancelHandlers()
+	defer close(s.done)
+	s.connMu.Lock()
+	s.cancelPackets = cancelHandlers
+	s.connMu.Unlock()
+
+	var sessions *packetSessions
+	if s.packet.sessions {
+		sessions = newPacketSessions(handlerCtx, s.packet.sessionTimeout)
+	}
+	closed := make(chan struct{})
+
+	s.Logger.Info(ctx, "Starting packet server")
+	for _, l := range listeners {
+		l := l
+		group.Go(func() error {
+			return s.readPackets(handlerCtx, l, workers, sessions, handle)
+		})
+	}
+	close(s.ready)
+
+	if sessions != nil {
+		group.Go(func() error {
+			sessions.expireLoop(closed)
+			return nil
+		})
+	}
+	group.Go(func() error {
+		s.acl.pruneLoop(closed)
+		return nil
+	})
+
+	group.Go(func() error {
+		defer close(closed)
+		return s.awaitStop(ctx, eCtx)
+	})
+	if err = group.Wait(); err != nil {
+		cancelHandlers()
+	}
+	workers.Wait()
+	return err
+}
+
+func (s *Server) readPackets(ctx context.Context, l *listener, workers *errgroup.Group, sessions *packetSessions, handle PacketHandleFunc) error {
+	buff := make([]byte, s.packet.size)
+	for {
+		n, addr, err := l.packetConn.ReadFrom(buff)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				s.Logger.Debug(ctx, "Read timed out")
+				continue
+			} else if errors.Is(err, net.ErrClosed) {
+				s.Logger.Debug(ctx, "Packet listener %s closed", l.name)
+				return nil
+			}
+			s.Logger.Error(ctx, err.Error())
+			return fmt.Errorf("could not read packet: %w", err)
+		}
+
+		if _, ok := s.admit(ctx, addr, false); !ok {
+			continue
+		}
+
+		data := make([]byte, n)
+		copy(data, buff[:n])
+		packetCtx := ctx
+		if sessions != nil {
+			packetCtx = context.WithValue(ctx, contextKeyPacketSession, sessions.touch(addr))
+		}
+		reply := func(data []byte) error {
+			_, err := l.packetConn.WriteTo(data, addr)
+			return err
+		}
+		workers.Go(func() error {
+			if err := handle(packetCtx, addr, data, reply); err != nil {
+				s.Logger.Error(packetCtx, err.Error())
+			}
+			return nil
+		})
+	}
+}
+
+// PacketSession tracks the datagrams exchanged with a single peer. Sessions
+// are only tracked if PACKET.SESSIONS is enabled and expire after
+// PACKET.SESSIONTIMEOUT without traffic.
+type PacketSession struct {
+	sync.Mutex
+	Addr     net.Addr
+	Started  time.Time
+	lastSeen time.Time
+	packets  uint64
+	values   map[string]interface{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// Context returns a context that is cancelled when the session expires.
+func (ps *PacketSession) Context() context.Context {
+	return ps.ctx
+}
+
+// LastSeen returns the time the last datagram of the peer was received.
+func (ps *PacketSession) LastSeen() time.Time {
+	ps.Lock()
+	defer ps.Unlock()
+	return ps.lastSeen
+}
+
+// Packets returns the number of datagrams received from the peer.
+func (ps *PacketSession) Packets() uint64 {
+	ps.Lock()
+	defer ps.Unlock()
+	return ps.packets
+}
+
+// Get returns a value previously stored in the session.
+func (ps *PacketSession) Get(key string) (interface{}, bool) {
+	ps.Lock()
+	defer ps.Unlock()
+	value, ok := ps.values[key]
+	return value, ok
+}
+
+// Set stores a value in the session.
+func (ps *PacketSession) Set(key string, value interface{}) {
+	ps.Lock()
+	ps.values[key] = value
+	ps.Unlock()
+}
+
+// PacketSessionFromContext returns the session of the peer the handled
+// datagram was received from.
+func PacketSessionFromContext(ctx context.Context) (*PacketSession, bool) {
+	session, ok := ctx.Value(contextKeyPacketSession).(*PacketSession)
+	return session, ok
+}
+
+type packetSessions struct {
+	sync.Mutex
+	ctx      context.Context
+	timeout  time.Duration
+	sessions map[string]*PacketSession
+}
+
+func newPacketSessions(ctx context.Context, timeout time.Duration) *packetSessions {
+	return &packetSessions{
+		ctx:      ctx,
+		timeout:  timeout,
+		sessions: make(map[string]*PacketSession),
+	}
+}
+
+func (ps *packetSessions) touch(addr net.Addr) *PacketSession {
+	now := time.Now()
+	ps.Lock()
+	session, ok := ps.sessions[addr.String()]
+	if !ok {
+		sessionCtx, cancel := context.WithCancel(ps.ctx)
+		session = &PacketSession{
+			Addr:    addr,
+			Started: now,
+			values:  make(map[string]interface{}),
+			ctx:     sessionCtx,
+			cancel:  cancel,
+		}
+		ps.sessions[addr.String()] = session
+	}
+	ps.Unlock()
+
+	session.Lock()
+	session.lastSeen = now
+	session.packets++
+	session.Unlock()
+	return session
+}
+
+// expireLoop removes idle sessions until closed is closed, then ends all
+// remaining sessions.
+func (ps *packetSessions) expireLoop(closed <-chan struct{}) {
+	ticker := time.NewTicker(max(ps.timeout/2, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			ps.expire(time.Time{})
+			return
+		case now := <-ticker.C:
+			ps.expire(now.Add(-ps.timeout))
+		}
+	}
+}
+
+// expire ends all sessions idle since before the given time. A zero time
+// ends all sessions.
+func (ps *packetSessions) expire(before time.Time) {
+	ps.Lock()
+	defer ps.Unlock()
+	for key, session := range ps.sessions {
+		if before.IsZero() || session.LastSeen().Before(before) {
+			session.cancel()
+			delete(ps.sessions, key)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package base
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func startPacketServer(t *testing.T, options map[string]interface{}, handle PacketHandleFunc) *Server {
+	t.Helper()
+	ctx := context.Background()
+	values := map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+		"TYPE":    "udp",
+	}
+	for key, value := range options {
+		values[key] = value
+	}
+	conf, err := config.WithInitialValues(ctx, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ServePacket(ctx, handle)
+	}()
+	t.Cleanup(func() {
+		if err := server.Stop(ctx); err != nil {
+			t.Error(err)
+		}
+		assert.NoError(t, <-served)
+	})
+	return server
+}
+
+func TestServePacket(t *testing.T) {
+	server := startPacketServer(t, nil, func(ctx context.Context, addr net.Addr, data []byte, reply ReplyFunc) error {
+		return reply(append([]byte("echo: "), data...))
+	})
+	assert.Len(t, server.Addrs(), 1)
+	assert.ErrorIs(t, server.Serve(context.Background(), testHandle), ErrNotStreamListener)
+
+	conn, err := net.Dial("udp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(testMsg); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buff := make([]byte, 64)
+	n, err := conn.Read(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "echo: "+string(testMsg), string(buff[:n]))
+}
+
+func TestPacketSessions(t *testing.T) {
+	server := startPacketServer(t, map[string]interface{}{
+		"MAXCONN": 1,
+		"PACKET": map[string]interface{}{
+			"SESSIONS":       true,
+			"SESSIONTIMEOUT": "1m",
+		},
+	}, func(ctx context.Context, addr net.Addr, data []byte, reply ReplyFunc) error {
+		session, ok := PacketSessionFromContext(ctx)
+		if !ok {
+			return reply([]byte("no session"))
+		}
+		count, _ := session.Get("count")
+		next := 1
+		if count != nil {
+			next = count.(int) + 1
+		}
+		session.Set("count", next)
+		return reply([]byte{byte(next)})
+	})
+
+	conn, err := net.Dial("udp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buff := make([]byte, 64)
+	for i := 1; i <= 3; i++ {
+		if _, err := conn.Write(testMsg); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []byte{byte(i)}, buff[:n])
+	}
+}
+
+func TestPacketSessionsExpire(t *testing.T) {
+	sessions := newPacketSessions(context.Background(), time.Minute)
+	addr := &net.UDPAddr{IP: net.ParseIP(tADDRESS), Port: 1234}
+	first := sessions.touch(addr)
+	assert.Same(t, first, sessions.touch(addr))
+	assert.Equal(t, uint64(2), first.Packets())
+
+	sessions.expire(time.Now().Add(time.Second))
+	assert.Error(t, first.Context().Err())
+	assert.NotSame(t, first, sessions.touch(addr))
+}
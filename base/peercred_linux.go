@@ -0,0 +1,30 @@
+//go:build linux
+
+package base
+
+import (
+	"net"
+	"syscall"
+)
+
+func readPeerCredentials(conn *net.UnixConn) (PeerCredentials, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+	var ucred *syscall.Ucred
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, sockErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return PeerCredentials{}, err
+	} else if sockErr != nil {
+		return PeerCredentials{}, sockErr
+	}
+	return PeerCredentials{
+		PID: ucred.Pid,
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+	}, nil
+}
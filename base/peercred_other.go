@@ -0,0 +1,9 @@
+//go:build !linux
+
+package base
+
+import "net"
+
+func readPeerCredentials(conn *net.UnixConn) (PeerCredentials, error) {
+	return PeerCredentials{}, ErrPeerCredentialsUnsupported
+}
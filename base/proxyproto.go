@@ -0,0 +1,357 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+)
+
+var (
+	// ErrProxyHeader indicates a malformed PROXY protocol header.
+	ErrProxyHeader = errors.New("invalid proxy protocol header")
+
+	// ErrProxyHeaderMissing indicates that a trusted upstream did not send
+	// the required PROXY protocol header.
+	ErrProxyHeaderMissing = errors.New("proxy protocol header missing")
+
+	// ErrProxyConfig indicates an invalid PROXYPROTOCOL configuration.
+	ErrProxyConfig = errors.New("invalid proxy protocol config")
+)
+
+// PROXY protocol v2 TLV types, see the HAProxy PROXY protocol specification.
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+const (
+	proxyV1MaxLength = 107
+	proxyV2HeaderLen = 16
+
+	// proxyPeekTimeout bounds the wait for an optional header, clients of
+	// protocols where the server speaks first send nothing on their own
+	proxyPeekTimeout = 500 * time.Millisecond
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyHeader is the decoded PROXY protocol header sent by an upstream load
+// balancer in front of the server.
+type ProxyHeader struct {
+	// Version is the protocol version, 1 or 2.
+	Version int
+	// Local is set for health checks of the upstream itself; the connection
+	// addresses are not replaced in that case.
+	Local bool
+	// Source is the address of the original client.
+	Source net.Addr
+	// Destination is the address the original client connected to.
+	Destination net.Addr
+	// TLVs are the type-length-value extensions of a v2 header.
+	TLVs []ProxyTLV
+}
+
+// ProxyTLV is a single type-length-value extension of a v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// TLV returns the value of the first extension of the given type.
+func (h *ProxyHeader) TLV(tlvType byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == tlvType {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ProxyHeaderFromContext returns the PROXY protocol header of the handled
+// connection.
+func ProxyHeaderFromContext(ctx context.Context) (*ProxyHeader, bool) {
+	info, ok := ConnFromContext(ctx)
+	if !ok || info.Proxy == nil {
+		return nil, false
+	}
+	return info.Proxy, true
+}
+
+type proxyOptions struct {
+	trusted   prefixList
+	trustUnix bool
+	required  bool
+}
+
+// initProxyProtocol parses the PROXYPROTOCOL config of a listener. It
+// returns nil if the protocol is disabled.
+func initProxyProtocol(ctx context.Context, proxyConfig *config.Config) (*proxyOptions, error) {
+	enabledRaw, _ := proxyConfig.Get(ctx, "ENABLED")
+	if enabled, _ := strconv.ParseBool(enabledRaw); !enabled {
+		return nil, nil
+	}
+	options := &proxyOptions{}
+	trustedRaw, _ := proxyConfig.Get(ctx, "TRUSTED")
+	var cidrs []string
+	for _, entry := range strings.Split(trustedRaw, ",") {
+		if strings.TrimSpace(entry) == "unix" {
+			options.trustUnix = true
+		} else {
+			cidrs = append(cidrs, entry)
+		}
+	}
+	trusted, err := parsePrefixList(strings.Join(cidrs, ","))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrProxyConfig, err.Error())
+	}
+	if len(trusted) == 0 && !options.trustUnix {
+		return nil, fmt.Errorf("%w: no trusted upstreams configured", ErrProxyConfig)
+	}
+	options.trusted = trusted
+	requiredRaw, _ := proxyConfig.Get(ctx, "REQUIRED")
+	options.required, _ = strconv.ParseBool(requiredRaw)
+	return options, nil
+}
+
+func (p *proxyOptions) isTrusted(addr net.Addr) bool {
+	if _, ok := addr.(*net.UnixAddr); ok {
+		return p.trustUnix
+	}
+	ip, ok := addrIP(addr)
+	return ok && p.trusted.contains(ip)
+}
+
+// proxyConn replaces the addresses of a connection with the ones sent in the
+// PROXY protocol header. Reads go through the buffered reader the header was
+// parsed from.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	header *ProxyHeader
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.header.Local || c.header.Source == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.header.Source
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.header.Local || c.header.Destination == nil {
+		return c.Conn.LocalAddr()
+	}
+	return c.header.Destination
+}
+
+// acceptProxyHeader reads the PROXY protocol header of a connection from a
+// trusted upstream. Connections from other sources are returned unchanged.
+func (s *Server) acceptProxyHeader(options *proxyOptions, conn net.Conn) (net.Conn, *ProxyHeader, error) {
+	if !options.isTrusted(conn.RemoteAddr()) {
+		return conn, nil, nil
+	}
+	timeout := s.ioTimeout()
+	if !options.required && proxyPeekTimeout < timeout {
+		timeout = proxyPeekTimeout
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	header, err := readProxyHeader(reader)
+	if errors.Is(err, ErrProxyHeaderMissing) && !options.required {
+		header, err = nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, nil, err
+	}
+	if header == nil {
+		return &proxyConn{Conn: conn, reader: reader, header: &ProxyHeader{Local: true}}, nil, nil
+	}
+	return &proxyConn{Conn: conn, reader: reader, header: header}, header, nil
+}
+
+// readProxyHeader detects and decodes a v1 or v2 header. It returns
+// ErrProxyHeaderMissing without consuming any data if the stream does not
+// start with a header or the read deadline passes before it is complete.
+func readProxyHeader(reader *bufio.Reader) (*ProxyHeader, error) {
+	start, err := reader.Peek(len(proxyV2Signature))
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, ErrProxyHeaderMissing
+	}
+	if err != nil && !(errors.Is(err, io.EOF) && len(start) > 0) {
+		return nil, err
+	}
+	if bytes.Equal(start, proxyV2Signature) {
+		return readProxyHeaderV2(reader)
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readProxyHeaderV1(reader)
+	}
+	return nil, ErrProxyHeaderMissing
+}
+
+func readProxyHeaderV1(reader *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrProxyHeader, err.Error())
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header too long", ErrProxyHeader)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	header := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		header.Local = true
+		return header, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("%w: v1 header has %d fields", ErrProxyHeader, len(fields))
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	if srcIP == nil || dstIP == nil {
+		return nil, fmt.Errorf("%w: invalid v1 address", ErrProxyHeader)
+	}
+	switch fields[1] {
+	case "TCP4":
+		if srcIP.To4() == nil || dstIP.To4() == nil {
+			return nil, fmt.Errorf("%w: TCP4 header with ipv6 address", ErrProxyHeader)
+		}
+	case "TCP6":
+	default:
+		return nil, fmt.Errorf("%w: unknown v1 protocol %s", ErrProxyHeader, fields[1])
+	}
+	srcPort, err := parseProxyPort(fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dstPort, err := parseProxyPort(fields[5])
+	if err != nil {
+		return nil, err
+	}
+	header.Source = &net.TCPAddr{IP: srcIP, Port: srcPort}
+	header.Destination = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	return header, nil
+}
+
+func parseProxyPort(raw string) (int, error) {
+	port, err := strconv.ParseUint(raw, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid port %s", ErrProxyHeader, raw)
+	}
+	return int(port), nil
+}
+
+func readProxyHeaderV2(reader *bufio.Reader) (*ProxyHeader, error) {
+	fixed := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(reader, fixed); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrProxyHeader, err.Error())
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrProxyHeader, fixed[12]>>4)
+	}
+	command := fixed[12] & 0x0f
+	if command > 1 {
+		return nil, fmt.Errorf("%w: unknown command %d", ErrProxyHeader, command)
+	}
+	family, transport := fixed[13]>>4, fixed[13]&0x0f
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrProxyHeader, err.Error())
+	}
+
+	header := &ProxyHeader{Version: 2, Local: command == 0}
+	var addrLen int
+	switch family {
+	case 0x0:
+		header.Local = true
+	case 0x1:
+		addrLen = 12
+		if len(payload) < addrLen {
+			return nil, fmt.Errorf("%w: short ipv4 address block", ErrProxyHeader)
+		}
+		header.Source, header.Destination = proxyV2InetAddrs(transport, payload[0:4], payload[4:8], payload[8:10], payload[10:12])
+	case 0x2:
+		addrLen = 36
+		if len(payload) < addrLen {
+			return nil, fmt.Errorf("%w: short ipv6 address block", ErrProxyHeader)
+		}
+		header.Source, header.Destination = proxyV2InetAddrs(transport, payload[0:16], payload[16:32], payload[32:34], payload[34:36])
+	case 0x3:
+		addrLen = 216
+		if len(payload) < addrLen {
+			return nil, fmt.Errorf("%w: short unix address block", ErrProxyHeader)
+		}
+		network := "unix"
+		if transport == 0x2 {
+			network = "unixgram"
+		}
+		header.Source = &net.UnixAddr{Name: string(bytes.TrimRight(payload[0:108], "\x00")), Net: network}
+		header.Destination = &net.UnixAddr{Name: string(bytes.TrimRight(payload[108:216], "\x00")), Net: network}
+	default:
+		return nil, fmt.Errorf("%w: unknown address family %d", ErrProxyHeader, family)
+	}
+
+	tlvs, err := parseProxyTLVs(payload[addrLen:])
+	if err != nil {
+		return nil, err
+	}
+	header.TLVs = tlvs
+	return header, nil
+}
+
+func proxyV2InetAddrs(transport byte, src, dst, srcPort, dstPort []byte) (net.Addr, net.Addr) {
+	srcIP, dstIP := net.IP(bytes.Clone(src)), net.IP(bytes.Clone(dst))
+	sPort, dPort := int(binary.BigEndian.Uint16(srcPort)), int(binary.BigEndian.Uint16(dstPort))
+	if transport == 0x2 {
+		return &net.UDPAddr{IP: srcIP, Port: sPort}, &net.UDPAddr{IP: dstIP, Port: dPort}
+	}
+	return &net.TCPAddr{IP: srcIP, Port: sPort}, &net.TCPAddr{IP: dstIP, Port: dPort}
+}
+
+func parseProxyTLVs(raw []byte) ([]ProxyTLV, error) {
+	var tlvs []ProxyTLV
+	for len(raw) > 0 {
+		if len(raw) < 3 {
+			return nil, fmt.Errorf("%w: truncated tlv", ErrProxyHeader)
+		}
+		length := int(binary.BigEndian.Uint16(raw[1:3]))
+		if len(raw) < 3+length {
+			return nil, fmt.Errorf("%w: truncated tlv value", ErrProxyHeader)
+		}
+		tlvs = append(tlvs, ProxyTLV{Type: raw[0], Value: bytes.Clone(raw[3 : 3+length])})
+		raw = raw[3+length:]
+	}
+	return tlvs, nil
+}
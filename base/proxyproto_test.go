@@ -0,0 +1,187 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildProxyV2Header(command byte, src, dst *net.TCPAddr, tlvs []ProxyTLV) []byte {
+	payload := &bytes.Buffer{}
+	payload.Write(src.IP.To4())
+	payload.Write(dst.IP.To4())
+	binary.Write(payload, binary.BigEndian, uint16(src.Port))
+	binary.Write(payload, binary.BigEndian, uint16(dst.Port))
+	for _, tlv := range tlvs {
+		payload.WriteByte(tlv.Type)
+		binary.Write(payload, binary.BigEndian, uint16(len(tlv.Value)))
+		payload.Write(tlv.Value)
+	}
+	header := &bytes.Buffer{}
+	header.Write(proxyV2Signature)
+	header.WriteByte(0x20 | command)
+	header.WriteByte(0x11)
+	binary.Write(header, binary.BigEndian, uint16(payload.Len()))
+	header.Write(payload.Bytes())
+	return header.Bytes()
+}
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	reader := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nHello"))
+	header, err := readProxyHeader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, header.Version)
+	assert.Equal(t, "192.0.2.1:56324", header.Source.String())
+	assert.Equal(t, "198.51.100.1:443", header.Destination.String())
+	rest, _ := io.ReadAll(reader)
+	assert.Equal(t, "Hello", string(rest))
+
+	reader = bufio.NewReader(bytes.NewBufferString("PROXY UNKNOWN\r\n"))
+	header, err = readProxyHeader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, header.Local)
+
+	_, err = readProxyHeader(bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.0.2.1 nope 1 2\r\n")))
+	assert.ErrorIs(t, err, ErrProxyHeader)
+
+	_, err = readProxyHeader(bufio.NewReader(bytes.NewBufferString("SSH-2.0-client\r\n")))
+	assert.ErrorIs(t, err, ErrProxyHeaderMissing)
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 22}
+	raw := buildProxyV2Header(0x1, src, dst, []ProxyTLV{
+		{Type: ProxyTLVAuthority, Value: []byte("example.com")},
+		{Type: ProxyTLVUniqueID, Value: []byte{1, 2, 3}},
+	})
+	reader := bufio.NewReader(bytes.NewReader(append(raw, []byte("Hello")...)))
+	header, err := readProxyHeader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, header.Version)
+	assert.False(t, header.Local)
+	assert.Equal(t, src.String(), header.Source.String())
+	assert.Equal(t, dst.String(), header.Destination.String())
+	authority, ok := header.TLV(ProxyTLVAuthority)
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", string(authority))
+	_, ok = header.TLV(ProxyTLVSSL)
+	assert.False(t, ok)
+	rest, _ := io.ReadAll(reader)
+	assert.Equal(t, "Hello", string(rest))
+
+	truncated := buildProxyV2Header(0x1, src, dst, []ProxyTLV{{Type: ProxyTLVNoop, Value: []byte("abc")}})
+	binary.BigEndian.PutUint16(truncated[len(truncated)-5:], 10)
+	_, err = readProxyHeader(bufio.NewReader(bytes.NewReader(truncated)))
+	assert.ErrorIs(t, err, ErrProxyHeader)
+}
+
+func TestServeProxyProtocol(t *testing.T) {
+	ctx := context.Background()
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+		"PROXYPROTOCOL": map[string]interface{}{
+			"ENABLED": true,
+			"TRUSTED": "127.0.0.0/8",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := server.Serve(ctx, func(ctx context.Context, conn net.Conn) error {
+			_, err := conn.Write([]byte(conn.RemoteAddr().String()))
+			return err
+		}); err != nil {
+			panic(err)
+		}
+	}()
+	defer server.Stop(ctx)
+
+	remoteAddr := func(header []byte) string {
+		conn, err := net.Dial("tcp", server.Addrs()[0].String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write(header); err != nil {
+			t.Fatal(err)
+		}
+		buff, _ := io.ReadAll(conn)
+		return string(buff)
+	}
+
+	assert.Equal(t, "203.0.113.7:4242", remoteAddr([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 4242 22\r\n")))
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.8"), Port: 4343}
+	assert.Equal(t, src.String(), remoteAddr(buildProxyV2Header(0x1, src, src, nil)))
+	// headers are required from trusted upstreams
+	assert.Equal(t, "", remoteAddr([]byte("no header at all\r\n")))
+}
+
+func TestServeProxyProtocolOptional(t *testing.T) {
+	ctx := context.Background()
+	server := startHookServer(t, map[string]interface{}{
+		"PROXYPROTOCOL": map[string]interface{}{
+			"ENABLED":  true,
+			"TRUSTED":  "127.0.0.0/8",
+			"REQUIRED": false,
+		},
+	}, func(*Server) {}, func(ctx context.Context, conn net.Conn) error {
+		_, err := conn.Write([]byte(conn.RemoteAddr().String()))
+		return err
+	})
+	defer server.Stop(ctx)
+
+	remoteAddr := func(header []byte) string {
+		conn, err := net.Dial("tcp", server.Addrs()[0].String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if header != nil {
+			if _, err := conn.Write(header); err != nil {
+				t.Fatal(err)
+			}
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buff, _ := io.ReadAll(conn)
+		return string(buff)
+	}
+
+	// a client waiting for the server to speak first is served without a
+	// header once the peek deadline passed
+	assert.Contains(t, remoteAddr(nil), "127.0.0.1:")
+	assert.Equal(t, "203.0.113.7:4242", remoteAddr([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 4242 22\r\n")))
+
+	// connections without a header are checked with the upstream address
+	aclConfig, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"DENY": "127.0.0.0/8",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.UpdateACL(ctx, aclConfig); err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, remoteAddr(nil))
+	assert.Equal(t, "203.0.113.7:4242", remoteAddr([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 4242 22\r\n")))
+}
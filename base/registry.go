@@ -0,0 +1,198 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrUnknownConnection indicates that no active connection has the given ID.
+var ErrUnknownConnection = errors.New("unknown connection")
+
+const contextKeyConnEntry = contextKey("conn-entry")
+
+// ConnID identifies a connection for the lifetime of the server.
+type ConnID uint64
+
+func (id ConnID) String() string {
+	return fmt.Sprintf("conn-%d", uint64(id))
+}
+
+// ConnectionInfo is a snapshot of an active connection.
+type ConnectionInfo struct {
+	ID       ConnID
+	Listener string
+	// RemoteAddr is the address of the client, taken from the PROXY
+	// protocol header if present.
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	Started    time.Time
+	// BytesIn and BytesOut count the bytes on the wire, including TLS
+	// overhead.
+	BytesIn  uint64
+	BytesOut uint64
+	// Label is set by the handler with SetLabel.
+	Label string
+	// Annotations are set by the handler with Annotate.
+	Annotations map[string]string
+}
+
+// connEntry is the registry entry of an active connection.
+type connEntry struct {
+	sync.Mutex
+	id          ConnID
+	listener    string
+	conn        net.Conn
+	cancel      context.CancelFunc
+	started     time.Time
+	bytes       *ByteCounter
+	remoteAddr  net.Addr
+	localAddr   net.Addr
+	label       string
+	annotations map[string]string
+	capture     *captureConn
+}
+
+func (e *connEntry) setAddrs(conn net.Conn) {
+	e.Lock()
+	e.remoteAddr = conn.RemoteAddr()
+	e.localAddr = conn.LocalAddr()
+	e.Unlock()
+}
+
+func (e *connEntry) info() ConnectionInfo {
+	e.Lock()
+	defer e.Unlock()
+	info := ConnectionInfo{
+		ID:          e.id,
+		Listener:    e.listener,
+		RemoteAddr:  e.remoteAddr,
+		LocalAddr:   e.localAddr,
+		Started:     e.started,
+		BytesIn:     e.bytes.Read(),
+		BytesOut:    e.bytes.Written(),
+		Label:       e.label,
+		Annotations: make(map[string]string, len(e.annotations)),
+	}
+	for key, value := range e.annotations {
+		info.Annotations[key] = value
+	}
+	return info
+}
+
+// SetLabel sets a free form label on the connection handled with ctx, like
+// the name of the served resource. It is shown by Server.Connections.
+func SetLabel(ctx context.Context, label string) {
+	if entry, ok := ctx.Value(contextKeyConnEntry).(*connEntry); ok {
+		entry.Lock()
+		entry.label = label
+		entry.Unlock()
+	}
+}
+
+// Annotate sets a key value pair on the connection handled with ctx. It is
+// shown by Server.Connections. An empty value removes the key.
+func Annotate(ctx context.Context, key, value string) {
+	entry, ok := ctx.Value(contextKeyConnEntry).(*connEntry)
+	if !ok {
+		return
+	}
+	entry.Lock()
+	defer entry.Unlock()
+	if value == "" {
+		delete(entry.annotations, key)
+		return
+	}
+	if entry.annotations == nil {
+		entry.annotations = make(map[string]string)
+	}
+	entry.annotations[key] = value
+}
+
+// trackConn registers an accepted connection. cancel is called once the
+// connection is untracked, killed or the server shuts down.
+func (s *Server) trackConn(l *listener, conn net.Conn, cancel context.CancelFunc) *connEntry {
+	entry := &connEntry{
+		id:         ConnID(s.nextConnID.Add(1)),
+		listener:   l.name,
+		conn:       conn,
+		cancel:     cancel,
+		started:    time.Now(),
+		bytes:      &ByteCounter{},
+		remoteAddr: conn.RemoteAddr(),
+		localAddr:  conn.LocalAddr(),
+	}
+	s.connMu.Lock()
+	s.conns[entry.id] = entry
+	s.connMu.Unlock()
+	s.metrics.active.Inc()
+	return entry
+}
+
+func (s *Server) untrackConn(entry *connEntry) {
+	s.connMu.Lock()
+	delete(s.conns, entry.id)
+	s.closedConns++
+	s.connMu.Unlock()
+	s.metrics.active.Dec()
+	entry.cancel()
+}
+
+func (s *Server) activeConns() int {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return len(s.conns)
+}
+
+// closeConns force-closes all tracked connections and returns how many were
+// closed, along with closedConns at that time.
+func (s *Server) closeConns(ctx context.Context) (int, uint64) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	for _, entry := range s.conns {
+		if err := entry.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.Logger.Error(ctx, err.Error())
+		}
+	}
+	return len(s.conns), s.closedConns
+}
+
+// Connections returns a snapshot of all active connections ordered by ID.
+func (s *Server) Connections() []ConnectionInfo {
+	s.connMu.Lock()
+	entries := make([]*connEntry, 0, len(s.conns))
+	for _, entry := range s.conns {
+		entries = append(entries, entry)
+	}
+	s.connMu.Unlock()
+
+	infos := make([]ConnectionInfo, 0, len(entries))
+	for _, entry := range entries {
+		infos = append(infos, entry.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// Kill cancels the context of a connection's handler and closes the
+// connection.
+func (s *Server) Kill(ctx context.Context, id ConnID) error {
+	s.connMu.Lock()
+	entry, ok := s.conns[id]
+	s.connMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownConnection, id)
+	}
+	entry.cancel()
+	if err := entry.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	s.Logger.Info(ctx, "Killed connection %s from %s", id, entry.info().RemoteAddr)
+	return nil
+}
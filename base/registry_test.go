@@ -0,0 +1,60 @@
+package base
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionRegistry(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	server, _ := startTestServer(t, func(ctx context.Context, conn net.Conn) error {
+		SetLabel(ctx, "stuck")
+		Annotate(ctx, "user", "tester")
+		buff := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buff); err != nil {
+			return err
+		}
+		if _, err := conn.Write(testMsg); err != nil {
+			return err
+		}
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return nil
+	})
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	connections := server.Connections()
+	if len(connections) != 1 {
+		t.Fatalf("expected 1 connection, got %d", len(connections))
+	}
+	info := connections[0]
+	assert.Equal(t, "default", info.Listener)
+	assert.Equal(t, conn.LocalAddr().String(), info.RemoteAddr.String())
+	assert.Equal(t, "stuck", info.Label)
+	assert.Equal(t, map[string]string{"user": "tester"}, info.Annotations)
+	assert.Equal(t, uint64(4), info.BytesIn)
+	assert.Equal(t, uint64(len(testMsg)), info.BytesOut)
+
+	if err := server.Kill(context.Background(), info.ID); err != nil {
+		t.Fatal(err)
+	}
+	<-cancelled
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, server.Kill(context.Background(), info.ID+1), ErrUnknownConnection)
+}
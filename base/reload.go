@@ -0,0 +1,326 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	log "github.com/myLogic207/gotils/logger"
+	"github.com/myLogic207/pepper/internal/confutil"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrServerStopped indicates that the server no longer accepts connections.
+var ErrServerStopped = errors.New("server is stopped")
+
+// IgnoredKeysError is returned by Reload for config changes that can not
+// be applied to a running server, they take effect on the next start. All
+// other changes were applied.
+type IgnoredKeysError struct {
+	Keys []string
+}
+
+func (e *IgnoredKeysError) Error() string {
+	return fmt.Sprintf("changes require a restart: %s", strings.Join(e.Keys, ", "))
+}
+
+// reloadIgnored are the config sections only read by Listen.
+var reloadIgnored = []string{"LOGGER", "METRICS", "PACKET"}
+
+// acceptState lets Reload start accept loops for new listeners while Serve
+// is running.
+type acceptState struct {
+	group       *errgroup.Group
+	ctx         context.Context
+	connections *sync.WaitGroup
+	handle      HandleFunc
+	// closed is set once the listeners are closed for good
+	closed bool
+}
+
+func (as *acceptState) start(s *Server, l *listener) {
+	as.group.Go(func() error {
+		return s.acceptConnections(as.ctx, l, as.connections, as.handle)
+	})
+}
+
+// listenerChanges is the difference between the running listeners and a
+// new config.
+type listenerChanges struct {
+	// listeners is the new list of listeners, kept ones in place
+	listeners []*listener
+	// options are the new TLS and PROXY protocol settings of kept listeners
+	options map[*listener]listenerOptionsUpdate
+	added   []*listener
+	removed []*listener
+	ignored []string
+}
+
+type listenerOptionsUpdate struct {
+	tls   *tlsProvider
+	proxy *proxyOptions
+}
+
+// unixKeys are the UNIX settings that only apply when a socket is bound.
+var unixKeys = []string{"MODE", "OWNER", "GROUP"}
+
+func (lc listenerConfig) unixSetting(ctx context.Context, key string) string {
+	unixConfig, err := lc.GetConfig(ctx, "UNIX")
+	if err != nil || unixConfig == nil {
+		return ""
+	}
+	value, _ := unixConfig.Get(ctx, key)
+	return value
+}
+
+// planListeners compares the running listeners with the LISTENERS of next
+// and binds the new ones. Listeners are matched by name, a listener whose
+// network or address changed is replaced.
+func (s *Server) planListeners(ctx context.Context, next *config.Config, timeout time.Duration) (*listenerChanges, error) {
+	current := s.currentListeners()
+	if s.Listener != nil {
+		// the injected listener is kept, only its options are replaced
+		provider, proxy, err := s.listenerOptions(ctx, listenerConfig{root: next})
+		if err != nil {
+			return nil, err
+		}
+		return &listenerChanges{
+			listeners: current,
+			options:   map[*listener]listenerOptionsUpdate{current[0]: {tls: provider, proxy: proxy}},
+		}, nil
+	}
+	byName := make(map[string]*listener, len(current))
+	for _, l := range current {
+		byName[l.name] = l
+	}
+	oldConfigs := make(map[string]listenerConfig)
+	for i, lc := range listenerConfigs(ctx, s.Config) {
+		oldConfigs[lc.name(ctx, i)] = lc
+	}
+	packet := isPacketNetwork(current[0].network)
+
+	changes := &listenerChanges{options: make(map[*listener]listenerOptionsUpdate)}
+	kept := make(map[*listener]bool)
+	for i, lc := range listenerConfigs(ctx, next) {
+		name := lc.name(ctx, i)
+		prefix := ""
+		if lc.entry != nil {
+			prefix = "LISTENERS." + strconv.Itoa(i) + "."
+		}
+		network := lc.Get(ctx, "TYPE")
+		existing, found := byName[name]
+		if found && existing.network == network && existing.address == listenAddress(ctx, lc) {
+			provider, proxy, err := s.listenerOptions(ctx, lc)
+			if err != nil {
+				changes.close()
+				return nil, fmt.Errorf("listener %s: %w", name, err)
+			}
+			changes.options[existing] = listenerOptionsUpdate{tls: provider, proxy: proxy}
+			if old, ok := oldConfigs[name]; ok && isUnixNetwork(network) {
+				for _, key := range unixKeys {
+					if old.unixSetting(ctx, key) != lc.unixSetting(ctx, key) {
+						changes.ignored = append(changes.ignored, prefix+"UNIX."+key)
+					}
+				}
+			}
+			kept[existing] = true
+			changes.listeners = append(changes.listeners, existing)
+			continue
+		}
+		if packet || isPacketNetwork(network) {
+			// packet listeners are not rebound and stream and packet
+			// listeners can not be mixed
+			changes.ignored = append(changes.ignored, prefix+"TYPE", prefix+"ADDRESS", prefix+"PORT")
+			if found {
+				kept[existing] = true
+				changes.listeners = append(changes.listeners, existing)
+			}
+			continue
+		}
+		l, err := s.bind(ctx, newListenConfig(timeout), name, lc, nil)
+		if err != nil {
+			changes.close()
+			return nil, fmt.Errorf("could not bind listener %s: %w", name, err)
+		}
+		changes.added = append(changes.added, l)
+		changes.listeners = append(changes.listeners, l)
+	}
+	for _, l := range current {
+		if kept[l] {
+			continue
+		}
+		if packet {
+			changes.ignored = append(changes.ignored, "LISTENERS")
+			changes.listeners = append(changes.listeners, l)
+			continue
+		}
+		changes.removed = append(changes.removed, l)
+	}
+	return changes, nil
+}
+
+// close releases the listeners bound for changes that are not applied.
+func (lc *listenerChanges) close() {
+	for _, l := range lc.added {
+		l.close()
+	}
+}
+
+// applyListeners swaps the listeners, starts accepting on the new ones if
+// the server is running and closes the removed ones.
+func (s *Server) applyListeners(ctx context.Context, changes *listenerChanges) error {
+	s.listenMu.Lock()
+	if s.accepting != nil && s.accepting.closed {
+		s.listenMu.Unlock()
+		changes.close()
+		return ErrServerStopped
+	}
+	for l, options := range changes.options {
+		l.tls.Store(options.tls)
+		l.proxy.Store(options.proxy)
+	}
+	s.listeners = changes.listeners
+	if s.accepting != nil {
+		for _, l := range changes.added {
+			s.accepting.start(s, l)
+		}
+	}
+	s.listenMu.Unlock()
+
+	for _, l := range changes.removed {
+		if err := l.close(); err != nil {
+			s.Logger.Error(ctx, "Could not close listener %s: %s", l.name, err.Error())
+		}
+		s.Logger.Info(ctx, "Closed listener %s on %s", l.name, l.Addr().String())
+	}
+	return nil
+}
+
+// Reload applies a new config to the running server. MAXCONN, TIMEOUT and
+// the ACL, BANDWIDTH, OVERLOAD, CAPTURE, ACCEPT, TLS and PROXYPROTOCOL
+// settings apply to new connections, listeners whose address changed are
+// rebound. Established connections are not affected. Packet servers size
+// their worker pool once, so MAXCONN is reported as ignored. The config is
+// validated and new listeners are bound before anything is applied, so an
+// error other than *IgnoredKeysError leaves the server unchanged.
+func (s *Server) Reload(ctx context.Context, serverOptions *config.Config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	next, err := config.WithInitialValuesAndOptions(ctx, defaultServerConfig, serverOptions)
+	if err != nil {
+		return fmt.Errorf("could not initialize config: %w", err)
+	}
+
+	timeout, err := parseTimeout(ctx, next)
+	if err != nil {
+		return err
+	}
+	overload, err := parseOverload(ctx, next)
+	if err != nil {
+		return fmt.Errorf("could not parse overload policy: %w", err)
+	}
+	aclConfig, err := next.GetConfig(ctx, "ACL")
+	if err != nil {
+		aclConfig = nil
+	}
+	aclPolicy, err := parseAccessPolicy(ctx, aclConfig)
+	if err != nil {
+		return fmt.Errorf("could not parse acl: %w", err)
+	}
+	bandwidthConfig, err := next.GetConfig(ctx, "BANDWIDTH")
+	if err != nil {
+		bandwidthConfig = nil
+	}
+	bandwidthPolicy, err := parseBandwidthPolicy(ctx, bandwidthConfig)
+	if err != nil {
+		return fmt.Errorf("could not parse bandwidth limits: %w", err)
+	}
+	capture, err := parseCapture(ctx, next)
+	if err != nil {
+		return err
+	}
+	backoff, err := parseAcceptBackoff(ctx, next)
+	if err != nil {
+		return err
+	}
+	changes, err := s.planListeners(ctx, next, timeout)
+	if err != nil {
+		return err
+	}
+	if err := s.applyListeners(ctx, changes); err != nil {
+		return err
+	}
+
+	s.timeout.Store(int64(timeout))
+	s.limiter.resize(overload.limit, overload.queueSize)
+	s.overload.Store(overload)
+	s.acl.policy.Store(aclPolicy)
+	s.bandwidth.policy.Store(bandwidthPolicy)
+	s.capture.Store(capture)
+	s.backoff.Store(backoff)
+
+	var keys []string
+	for _, key := range confutil.Keys(defaultServerConfig) {
+		if section, _, _ := strings.Cut(key, "."); slices.Contains(reloadIgnored, section) {
+			keys = append(keys, key)
+		}
+	}
+	if listeners := s.currentListeners(); len(listeners) > 0 && listeners[0].packetConn != nil {
+		keys = append(keys, "MAXCONN")
+	}
+	ignored := append(confutil.Changed(ctx, s.Config, next, keys), changes.ignored...)
+	slices.Sort(ignored)
+	ignored = slices.Compact(ignored)
+	s.listenMu.Lock()
+	s.Config = next
+	s.listenMu.Unlock()
+	s.Logger.Info(ctx, "Reloaded config, %d listeners added and %d removed", len(changes.added), len(changes.removed))
+	if len(ignored) > 0 {
+		return &IgnoredKeysError{Keys: ignored}
+	}
+	return nil
+}
+
+// OnSignal calls fn whenever one of signals, SIGHUP if none are given, is
+// received until ctx is done. Errors of fn are logged.
+func OnSignal(ctx context.Context, logger log.Logger, fn func(context.Context) error, signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+	defer signal.Stop(received)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-received:
+			logger.Info(ctx, "Received %s", sig.String())
+			if err := fn(ctx); err != nil {
+				logger.Error(ctx, err.Error())
+			}
+		}
+	}
+}
+
+// ReloadOnSignal reloads the server with the config returned by load
+// whenever one of signals, SIGHUP if none are given, is received. It blocks
+// until ctx is done.
+func (s *Server) ReloadOnSignal(ctx context.Context, load func(context.Context) (*config.Config, error), signals ...os.Signal) {
+	OnSignal(ctx, s.Logger, func(ctx context.Context) error {
+		conf, err := load(ctx)
+		if err != nil {
+			return fmt.Errorf("could not load config: %w", err)
+		}
+		return s.Reload(ctx, conf)
+	}, signals...)
+}
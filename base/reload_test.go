@@ -0,0 +1,168 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func reloadConfig(t *testing.T, options map[string]interface{}) *config.Config {
+	t.Helper()
+	options["ADDRESS"] = tADDRESS
+	options["PORT"] = tPORT
+	conf, err := config.WithInitialValues(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestReloadMaxConn(t *testing.T) {
+	overload := map[string]interface{}{
+		"POLICY":    "queue",
+		"QUEUESIZE": 5,
+	}
+	handle, started, unblock := blockingHandle()
+	defer close(unblock)
+	server := startHookServer(t, map[string]interface{}{
+		"MAXCONN":  1,
+		"OVERLOAD": overload,
+	}, func(s *Server) {}, handle)
+	addr := server.Addrs()[0].String()
+
+	blocked, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocked.Close()
+	<-started
+	queued := make(chan []byte, 1)
+	go func() {
+		queued <- dialAndRead(t, addr)
+	}()
+	assert.Eventually(t, func() bool {
+		return server.OverloadStats().QueueDepth == 1
+	}, time.Second, 10*time.Millisecond)
+
+	err = server.Reload(context.Background(), reloadConfig(t, map[string]interface{}{
+		"MAXCONN":  2,
+		"OVERLOAD": overload,
+	}))
+	assert.NoError(t, err)
+	select {
+	case buff := <-queued:
+		assert.Equal(t, testMsg, buff)
+	case <-time.After(time.Second):
+		t.Fatal("queued connection was not served after raising MAXCONN")
+	}
+}
+
+func TestReloadListeners(t *testing.T) {
+	listeners := func(names ...string) map[string]interface{} {
+		entries := map[string]interface{}{}
+		for i, name := range names {
+			entries[string(rune('0'+i))] = map[string]interface{}{"NAME": name}
+		}
+		return map[string]interface{}{"LISTENERS": entries}
+	}
+	server := startHookServer(t, listeners("first"), func(s *Server) {}, testHandle)
+	first := server.Addrs()[0].String()
+
+	if err := server.Reload(context.Background(), reloadConfig(t, listeners("first", "second"))); err != nil {
+		t.Fatal(err)
+	}
+	addrs := server.Addrs()
+	if !assert.Len(t, addrs, 2) {
+		return
+	}
+	assert.Equal(t, first, addrs[0].String())
+	second := addrs[1].String()
+	assert.Equal(t, testMsg, dialAndRead(t, first))
+	assert.Equal(t, testMsg, dialAndRead(t, second))
+
+	if err := server.Reload(context.Background(), reloadConfig(t, listeners("second"))); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{second}, []string{server.Addrs()[0].String()})
+	assert.Equal(t, testMsg, dialAndRead(t, second))
+	_, err := net.DialTimeout("tcp", first, time.Second)
+	assert.Error(t, err)
+}
+
+func TestReloadIgnoredKeys(t *testing.T) {
+	server := startHookServer(t, map[string]interface{}{}, func(s *Server) {}, testHandle)
+	err := server.Reload(context.Background(), reloadConfig(t, map[string]interface{}{
+		"LOGGER": map[string]interface{}{
+			"PREFIX": "RELOADED",
+		},
+		"ACL": map[string]interface{}{
+			"DENY": "127.0.0.0/8",
+		},
+	}))
+	var ignored *IgnoredKeysError
+	if !errors.As(err, &ignored) {
+		t.Fatalf("expected ignored keys, got %v", err)
+	}
+	assert.Equal(t, []string{"LOGGER.PREFIX"}, ignored.Keys)
+
+	// the ACL was applied nevertheless
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buff, _ := io.ReadAll(conn)
+	assert.Empty(t, buff)
+}
+
+func TestReloadInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	server := startHookServer(t, map[string]interface{}{}, func(s *Server) {}, testHandle)
+	err := server.Reload(context.Background(), reloadConfig(t, map[string]interface{}{
+		"MAXCONN": "many",
+		"CAPTURE": map[string]interface{}{
+			"DIR": dir,
+		},
+	}))
+	assert.Error(t, err)
+	_, err = server.StartCapture(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrCaptureDisabled)
+
+	err = server.Reload(context.Background(), reloadConfig(t, map[string]interface{}{
+		"LISTENERS": map[string]interface{}{
+			"0": map[string]interface{}{
+				"NAME": "socket",
+				"TYPE": "unix",
+				"UNIX": map[string]interface{}{
+					"PATH": filepath.Join(dir, "missing", "server.sock"),
+				},
+			},
+		},
+	}))
+	assert.Error(t, err)
+	assert.Equal(t, testMsg, dialAndRead(t, server.Addrs()[0].String()))
+}
+
+func TestReloadPacketMaxConn(t *testing.T) {
+	server := startPacketServer(t, map[string]interface{}{
+		"MAXCONN": 1,
+	}, func(ctx context.Context, addr net.Addr, data []byte, reply ReplyFunc) error {
+		return reply(data)
+	})
+	err := server.Reload(context.Background(), reloadConfig(t, map[string]interface{}{
+		"TYPE":    "udp",
+		"MAXCONN": 2,
+	}))
+	var ignored *IgnoredKeysError
+	if !errors.As(err, &ignored) {
+		t.Fatalf("expected ignored keys, got %v", err)
+	}
+	assert.Equal(t, []string{"MAXCONN"}, ignored.Keys)
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/myLogic207/gotils/config"
 	log "github.com/myLogic207/gotils/logger"
+	"github.com/myLogic207/pepper/metrics"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -23,18 +25,121 @@ var defaultServerConfig = map[string]interface{}{
 	"MAXCONN": "-1",
 	"TIMEOUT": "5s",
 	"TYPE":    "tcp",
+	"UNIX": map[string]interface{}{
+		"PATH":        "",
+		"MODE":        "0660",
+		"OWNER":       "",
+		"GROUP":       "",
+		"REMOVESTALE": true,
+	},
+	"PACKET": map[string]interface{}{
+		"SIZE":           65535,
+		"SESSIONS":       false,
+		"SESSIONTIMEOUT": "1m",
+	},
+	"ACL": map[string]interface{}{
+		"ALLOW":    "",
+		"DENY":     "",
+		"MAXPERIP": -1,
+		"RATE":     0,
+		"BURST":    0,
+	},
+	"BANDWIDTH": map[string]interface{}{
+		"READ":       0,
+		"WRITE":      0,
+		"BURST":      0,
+		"PERIPREAD":  0,
+		"PERIPWRITE": 0,
+		"PERIPBURST": 0,
+		"CONNREAD":   0,
+		"CONNWRITE":  0,
+		"CONNBURST":  0,
+	},
+	"CAPTURE": map[string]interface{}{
+		"DIR":     "",
+		"SOURCES": "",
+	},
+	"ACCEPT": map[string]interface{}{
+		"BACKOFFMIN": "5ms",
+		"BACKOFFMAX": "1s",
+	},
+	"METRICS": map[string]interface{}{
+		"NAME": "base",
+	},
+	"OVERLOAD": map[string]interface{}{
+		"POLICY":       "reject",
+		"MESSAGE":      "",
+		"QUEUESIZE":    0,
+		"QUEUETIMEOUT": "10s",
+	},
+	"PROXYPROTOCOL": map[string]interface{}{
+		"ENABLED":  false,
+		"TRUSTED":  "",
+		"REQUIRED": true,
+	},
+	"TLS": map[string]interface{}{
+		"ENABLED":        false,
+		"CERT":           "",
+		"KEY":            "",
+		"CLIENTCA":       "",
+		"CLIENTAUTH":     "none",
+		"MINVERSION":     "1.2",
+		"CIPHERS":        "",
+		"RELOADINTERVAL": "0s",
+	},
 }
 
 type HandleFunc func(context.Context, net.Conn) error
 
 type Server struct {
-	Logger   log.Logger
-	Config   *config.Config
-	listener net.Listener
-	stop     chan bool
+	Logger log.Logger
+	Config *config.Config
+	// Goodbye is written to connections rejected because the server is
+	// overloaded. It defaults to writing OVERLOAD.MESSAGE.
+	Goodbye GoodbyeFunc
+	// OnAccept can veto connections before they are served.
+	OnAccept AcceptHook
+	// OnReject is called for connections refused by the ACL, the rate and
+	// connection limits or OnAccept.
+	OnReject RejectHook
+	// OnHandlerError is called when the handler returns an error.
+	OnHandlerError HandlerErrorHook
+	// OnClose is called once a served connection is closed.
+	OnClose CloseHook
+	// Metrics receives the server metrics if set before Listen.
+	Metrics *metrics.Registry
+	// Listener replaces the configured listeners if set before Listen, e.g.
+	// with the in-memory listener of basetest. TLS and PROXYPROTOCOL still
+	// apply, the server closes it on Stop.
+	Listener    net.Listener
+	metrics     serverMetrics
+	listeners   []*listener
+	packet      packetOptions
+	acl         *accessControl
+	bandwidth   *bandwidthShaper
+	limiter     *connLimiter
+	overload    atomic.Pointer[overloadOptions]
+	backoff     atomic.Pointer[acceptBackoff]
+	capture     atomic.Pointer[captureOptions]
+	middlewares []Middleware
+	timeout     atomic.Int64
+	listenMu    sync.Mutex
+	reloadMu    sync.Mutex
+	accepting   *acceptState
+	quit        chan struct{}
+	quitOnce    sync.Once
+	ready       chan struct{}
+	done        chan struct{}
+	connMu      sync.Mutex
+	conns       map[ConnID]*connEntry
+	// closedConns counts the untracked connections, cancelPackets cancels
+	// the packet handlers, both are guarded by connMu
+	closedConns   uint64
+	cancelPackets context.CancelFunc
+	nextConnID    atomic.Uint64
 }
 
-// Listen starts listening on the configured address and port.
+// Listen starts listening on all configured addresses.
 func (s *Server) Listen(ctx context.Context, serverOptions *config.Config) error {
 	if s.Config == nil {
 		cnf, err := config.WithInitialValuesAndOptions(ctx, defaultServerConfig, serverOptions)
@@ -53,7 +158,36 @@ func (s *Server) Listen(ctx context.Context, serverOptions *config.Config) error
 		s.Logger = logger
 	}
 
-	if err := s.initListener(ctx); err != nil {
+	s.quit = make(chan struct{})
+	s.ready = make(chan struct{})
+	s.done = make(chan struct{})
+	s.conns = make(map[ConnID]*connEntry)
+
+	if err := s.initAccessControl(ctx); err != nil {
+		return fmt.Errorf("could not initialize acl: %w", err)
+	}
+
+	if err := s.initBandwidth(ctx); err != nil {
+		return fmt.Errorf("could not initialize bandwidth limits: %w", err)
+	}
+
+	if err := s.initOverload(ctx); err != nil {
+		return fmt.Errorf("could not initialize overload policy: %w", err)
+	}
+
+	if err := s.initCapture(ctx); err != nil {
+		return fmt.Errorf("could not initialize capture: %w", err)
+	}
+
+	if err := s.initAccept(ctx); err != nil {
+		return fmt.Errorf("could not initialize accept backoff: %w", err)
+	}
+
+	if err := s.initMetrics(ctx); err != nil {
+		return fmt.Errorf("could not register metrics: %w", err)
+	}
+
+	if err := s.initListeners(ctx); err != nil {
 		return fmt.Errorf("could not initialize listener: %w", err)
 	}
 
@@ -61,103 +195,217 @@ func (s *Server) Listen(ctx context.Context, serverOptions *config.Config) error
 }
 
 // Each connection is handled by a worker from the worker pool.
-// The handleFunc is called for each connection that is accepted.
+// The handleFunc is called for each connection that is accepted on any of
+// the listeners; MAXCONN limits the handlers running across all listeners.
 func (s *Server) Serve(ctx context.Context, handle HandleFunc) error {
-	maxconn, _ := s.Config.Get(ctx, "MAXCONN")
-	max, err := strconv.Atoi(maxconn)
-	if err != nil {
-		return fmt.Errorf("could not parse max connections: %s", maxconn)
+	if listeners := s.currentListeners(); len(listeners) == 0 || listeners[0].stream == nil {
+		return ErrNotStreamListener
 	}
-	connections, eCtx := errgroup.WithContext(ctx)
-	connections.SetLimit(max)
-	s.stop = make(chan bool)
-	defer close(s.stop)
+	group, eCtx := errgroup.WithContext(ctx)
+	connections := &sync.WaitGroup{}
+	// handlers outlive the accept loops on Stop, they are only cancelled if
+	// accepting fails or by Shutdown
+	handlerCtx, cancelHandlers := context.WithCancel(ctx)
+	defer cancelHandlers()
+	defer close(s.done)
 
+	handle = Chain(handle, s.middlewares...)
 	s.Logger.Info(ctx, "Starting server")
-	connections.Go(func() error {
-		return s.acceptConnections(eCtx, connections, handle)
+	s.listenMu.Lock()
+	s.accepting = &acceptState{group: group, ctx: handlerCtx, connections: connections, handle: handle}
+	for _, l := range s.listeners {
+		s.accepting.start(s, l)
+	}
+	s.listenMu.Unlock()
+	close(s.ready)
+
+	closed := make(chan struct{})
+	group.Go(func() error {
+		s.acl.pruneLoop(closed)
+		return nil
 	})
+	group.Go(func() error {
+		defer close(closed)
+		return s.awaitStop(ctx, eCtx)
+	})
+	err := group.Wait()
+	if err != nil {
+		cancelHandlers()
+	}
+	connections.Wait()
+	return err
+}
 
-	connections.Go(func() error {
-	running:
-		for {
-			select {
-			case <-s.stop:
-				break running
-			case <-eCtx.Done():
-				if err := eCtx.Err(); err != nil && !errors.Is(err, context.Canceled) {
-					s.Logger.Error(ctx, err.Error())
-				}
-				break running
-			}
+// awaitStop blocks until the server is stopped or eCtx is done and closes
+// all listeners afterwards.
+func (s *Server) awaitStop(ctx context.Context, eCtx context.Context) error {
+	select {
+	case <-s.quit:
+	case <-eCtx.Done():
+		if err := eCtx.Err(); err != nil && !errors.Is(err, context.Canceled) {
+			s.Logger.Error(ctx, err.Error())
 		}
-		return s.listener.Close()
-	})
-	return connections.Wait()
+	}
+	s.listenMu.Lock()
+	if s.accepting != nil {
+		s.accepting.closed = true
+	}
+	s.listenMu.Unlock()
+	return s.closeListeners()
 }
 
-func (s *Server) acceptConnections(ctx context.Context, connections *errgroup.Group, handle HandleFunc) error {
+func (s *Server) acceptConnections(ctx context.Context, l *listener, connections *sync.WaitGroup, handle HandleFunc) error {
+	backoff := *s.backoff.Load()
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := l.stream.Accept()
 		if err != nil {
-			if ne, ok := err.(net.Error); ok && ne.Timeout() {
-				s.Logger.Debug(ctx, "Accept timed out")
-				continue
-			} else if errors.Is(err, net.ErrClosed) {
-				s.Logger.Debug(ctx, "Listener closed")
-				return nil
+			if retry, err := s.handleAcceptError(ctx, l, &backoff, err); !retry {
+				return err
 			}
-			s.Logger.Error(ctx, err.Error())
-			return fmt.Errorf("could not accept connection: %w", err)
+			continue
 		}
+		// picks up limits changed by Reload
+		backoff = *s.backoff.Load()
 
-		s.Logger.Info(ctx, "Accepted connection from %s", conn.RemoteAddr().String())
-		connCtx := context.WithValue(ctx, "connection", conn)
-		connected := connections.TryGo(func() error {
-			if err := handle(connCtx, conn); err != nil {
-				s.Logger.Error(connCtx, err.Error())
-			}
-			if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
-				s.Logger.Error(ctx, err.Error())
+		// connections from trusted proxies are checked once the real source
+		// is known
+		release := func() {}
+		if proxy := l.proxy.Load(); proxy == nil || !proxy.isTrusted(conn.RemoteAddr()) {
+			admitted, ok := s.admit(ctx, conn.RemoteAddr(), true)
+			if !ok {
+				conn.Close()
+				continue
 			}
-			return nil
-		})
-		if !connected {
-			s.Logger.Error(connCtx, "Could not connect, would exceed max")
+			release = admitted
 		}
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			defer release()
+			// only connections that got a worker reach OnAccept and are
+			// counted and tracked
+			if reason, err := s.acquireWorker(ctx); err != nil {
+				s.rejectOverloaded(ctx, conn, reason, err)
+				return
+			}
+			defer s.limiter.release()
+			if !s.onAccept(ctx, conn) {
+				conn.Close()
+				return
+			}
+			s.metrics.accepted.With(s.metrics.name, l.name).Inc()
+
+			connCtx, cancel := context.WithCancel(ctx)
+			entry := s.trackConn(l, conn, cancel)
+			connCtx = context.WithValue(connCtx, contextKeyConnEntry, entry)
+			defer s.untrackConn(entry)
+			started := time.Now()
+			err := s.serveConn(connCtx, l, entry, handle)
+			s.metrics.connectionClosed(entry, time.Since(started))
+			s.onClose(connCtx, entry, err)
+		}()
 	}
 }
 
-func (s *Server) GetListener() net.Listener {
-	return s.listener
-}
+// serveConn runs the handler for a single connection and closes the
+// connection afterwards. The PROXY protocol header is decoded and TLS
+// connections are handshaked before the handler is called. The returned
+// error ended the connection.
+func (s *Server) serveConn(ctx context.Context, l *listener, entry *connEntry, handle HandleFunc) error {
+	conn := entry.conn
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.Logger.Error(ctx, err.Error())
+		}
+	}()
+	info := &ConnInfo{
+		ID:         entry.id,
+		Accepted:   entry.started,
+		Listener:   l.name,
+		RemoteAddr: conn.RemoteAddr(),
+		LocalAddr:  conn.LocalAddr(),
+	}
 
-func (s *Server) initListener(ctx context.Context) error {
-	addr, _ := s.Config.Get(ctx, "ADDRESS")
-	port, _ := s.Config.Get(ctx, "PORT")
-	address := fmt.Sprintf("%s:%s", addr, port)
-	timeoutRaw, _ := s.Config.Get(ctx, "TIMEOUT")
-	timeout, err := time.ParseDuration(timeoutRaw)
-	if err != nil {
-		return fmt.Errorf("could not parse timeout: %w", err)
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		if creds, err := readPeerCredentials(unixConn); err == nil {
+			info.PeerCredentials = &creds
+		} else if !errors.Is(err, ErrPeerCredentialsUnsupported) {
+			s.Logger.Error(ctx, "could not read peer credentials: %s", err.Error())
+		}
 	}
-	listenConfig := net.ListenConfig{
-		KeepAlive: timeout - (timeout / 10),
-		Control:   nil,
+	conn = &countingConn{Conn: conn, counter: entry.bytes}
+	ctx = context.WithValue(ctx, contextKeyByteCounter, entry.bytes)
+
+	if proxy := l.proxy.Load(); proxy != nil {
+		trusted := proxy.isTrusted(conn.RemoteAddr())
+		proxied, header, err := s.acceptProxyHeader(proxy, conn)
+		if err != nil {
+			s.Logger.Error(ctx, "Could not read proxy header from %s: %s", conn.RemoteAddr().String(), err.Error())
+			return err
+		}
+		conn = proxied
+		entry.setAddrs(conn)
+		info.RemoteAddr = conn.RemoteAddr()
+		info.LocalAddr = conn.LocalAddr()
+		info.Proxy = header
+		// health checks of the upstream bypass the ACL, connections without
+		// a header are checked with the address of the upstream
+		if trusted && (header == nil || !header.Local) {
+			release, ok := s.admit(ctx, conn.RemoteAddr(), true)
+			if !ok {
+				return ErrRejected
+			}
+			defer release()
+		}
+	}
+	s.Logger.Info(ctx, "Accepted connection from %s on %s", conn.RemoteAddr().String(), l.name)
+
+	shaped := s.bandwidth.shape(conn)
+	defer shaped.release()
+	conn = shaped
+	ctx = context.WithValue(ctx, contextKeyBandwidth, shaped)
+
+	if provider := l.tls.Load(); provider != nil {
+		tlsConn, err := s.handshakeTLS(ctx, provider, conn)
+		if err != nil {
+			s.Logger.Error(ctx, err.Error())
+			return err
+		}
+		conn = tlsConn
+		state := tlsConn.ConnectionState()
+		info.TLS = &state
 	}
-	connType, _ := s.Config.Get(ctx, "TYPE")
-	listener, err := listenConfig.Listen(ctx, connType, address)
+
+	captured := s.captureFromStart(ctx, entry, conn)
+	defer s.endCapture(ctx, captured)
+	conn = captured
+
+	ctx = context.WithValue(ctx, contextKeyConnInfo, info)
+	err := handle(ctx, conn)
 	if err != nil {
-		return err
+		s.Logger.Error(ctx, err.Error())
+		s.onHandlerError(ctx, conn, err)
 	}
-	s.listener = listener
-	s.Logger.Info(ctx, "Listening on %s", s.listener.Addr().String())
-	return nil
+	return err
+}
+
+// Ready returns a channel that is closed once Serve or ServePacket accepts
+// connections.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *Server) signalQuit() {
+	s.quitOnce.Do(func() {
+		close(s.quit)
+	})
 }
 
+// Stop stops accepting new connections and blocks until all running
+// handlers have returned.
 func (s *Server) Stop(ctx context.Context) error {
 	s.Logger.Info(ctx, "Stopping server")
-	s.stop <- true
-	<-s.stop
+	s.signalQuit()
+	<-s.done
 	return nil
 }
@@ -6,7 +6,6 @@ import (
 	"io"
 	"net"
 	"testing"
-	"time"
 
 	"github.com/myLogic207/gotils/config"
 	"github.com/stretchr/testify/assert"
@@ -54,7 +53,7 @@ func TestServe(t *testing.T) {
 		println("Server stopped")
 	}()
 
-	<-time.After(1 * time.Millisecond)
+	<-server.Ready()
 	// stop signal
 	if err := server.Stop(ctx); err != nil {
 		t.Fatal(err)
@@ -85,14 +84,12 @@ func TestConnect(t *testing.T) {
 		}
 	}()
 
-	conn, err := net.Dial("tcp", server.GetListener().Addr().String())
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer conn.Close()
 
-	<-time.After(1 * time.Millisecond)
-
 	buff, err := io.ReadAll(conn)
 	if err != nil {
 		t.Fatal(err)
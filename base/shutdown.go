@@ -0,0 +1,86 @@
+package base
+
+import (
+	"context"
+	"time"
+)
+
+// shutdownPollInterval is the interval in which Shutdown checks whether all
+// handlers have returned.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// DrainReport summarizes the outcome of a graceful shutdown.
+type DrainReport struct {
+	// Drained is the number of connections whose handlers returned before
+	// the shutdown deadline.
+	Drained int
+	// Killed is the number of connections that were force-closed because
+	// their handlers did not return in time.
+	Killed int
+}
+
+// Shutdown gracefully stops the server. It stops accepting new connections,
+// cancels the context of every running handler and waits for the handlers,
+// including those of packets, to return. Connections still open when ctx
+// expires are force-closed and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) (DrainReport, error) {
+	s.Logger.Info(ctx, "Shutting down server")
+	s.connMu.Lock()
+	closed := s.closedConns
+	s.connMu.Unlock()
+
+	s.signalQuit()
+	if err := s.closeListeners(); err != nil {
+		s.Logger.Error(ctx, err.Error())
+	}
+
+	s.connMu.Lock()
+	for _, entry := range s.conns {
+		entry.cancel()
+	}
+	if s.cancelPackets != nil {
+		s.cancelPackets()
+	}
+	s.connMu.Unlock()
+
+	report := DrainReport{}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.activeConns() == 0 && s.handlersReturned() {
+			report.Drained = s.drainedSince(closed)
+			s.Logger.Info(ctx, "Server shut down, drained %d connections", report.Drained)
+			return report, nil
+		}
+		select {
+		case <-ctx.Done():
+			killed, untracked := s.closeConns(ctx)
+			report.Killed = killed
+			report.Drained = int(untracked - closed)
+			s.Logger.Error(ctx, "Shutdown deadline exceeded, drained %d connections, killed %d", report.Drained, report.Killed)
+			return report, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// handlersReturned reports whether Serve or ServePacket returned after
+// waiting for all handlers, or whether the server never served.
+func (s *Server) handlersReturned() bool {
+	select {
+	case <-s.done:
+		return true
+	case <-s.ready:
+		return false
+	default:
+		return true
+	}
+}
+
+// drainedSince returns the number of connections untracked since closed
+// was read from closedConns.
+func (s *Server) drainedSince(closed uint64) int {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return int(s.closedConns - closed)
+}
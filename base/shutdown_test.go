@@ -0,0 +1,113 @@
+package base
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func startTestServer(t *testing.T, handle HandleFunc) (*Server, <-chan error) {
+	t.Helper()
+	ctx := context.Background()
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, handle)
+	}()
+	return server, served
+}
+
+func TestShutdownDrains(t *testing.T) {
+	started := make(chan struct{})
+	server, served := startTestServer(t, func(ctx context.Context, conn net.Conn) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	})
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	report, err := server.Shutdown(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, DrainReport{Drained: 1, Killed: 0}, report)
+	assert.NoError(t, <-served)
+}
+
+func TestShutdownKillsAfterDeadline(t *testing.T) {
+	started := make(chan struct{})
+	server, served := startTestServer(t, func(ctx context.Context, conn net.Conn) error {
+		close(started)
+		// ignores ctx and only returns once the connection is closed
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	})
+
+	conn, err := net.Dial("tcp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report, err := server.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, DrainReport{Drained: 0, Killed: 1}, report)
+	assert.NoError(t, <-served)
+
+	if _, err := net.Dial("tcp", server.Addrs()[0].String()); err == nil {
+		t.Fatal("server still accepts connections after shutdown")
+	}
+}
+
+func TestShutdownWaitsForPacketHandlers(t *testing.T) {
+	started := make(chan struct{})
+	var returned atomic.Bool
+	server := startPacketServer(t, nil, func(ctx context.Context, addr net.Addr, data []byte, reply ReplyFunc) error {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		returned.Store(true)
+		return nil
+	})
+
+	conn, err := net.Dial("udp", server.Addrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(testMsg); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	report, err := server.Shutdown(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, DrainReport{}, report)
+	assert.True(t, returned.Load(), "shutdown returned before the packet handler")
+}
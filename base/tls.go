@@ -0,0 +1,280 @@
+package base
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+)
+
+var (
+	// ErrTLSConfig indicates an invalid TLS configuration.
+	ErrTLSConfig = errors.New("invalid tls config")
+
+	// ErrTLSDisabled indicates that TLS is not enabled on the server.
+	ErrTLSDisabled = errors.New("tls is not enabled")
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"none":     tls.NoClientCert,
+	"request":  tls.RequestClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"required": tls.RequireAndVerifyClientCert,
+}
+
+type contextKey string
+
+// tlsProvider holds the TLS configuration of a listener. The certificate,
+// key and client CA files are re-read from disk whenever they change, so
+// certificates can be rotated without restarting the server.
+type tlsProvider struct {
+	sync.RWMutex
+	certFile       string
+	keyFile        string
+	clientCAFile   string
+	minVersion     uint16
+	cipherSuites   []uint16
+	clientAuth     tls.ClientAuthType
+	reloadInterval time.Duration
+	current        *tls.Config
+	modTimes       [3]time.Time
+	lastCheck      time.Time
+}
+
+func newTLSProvider(ctx context.Context, conf *config.Config) (*tlsProvider, error) {
+	p := &tlsProvider{}
+	p.certFile, _ = conf.Get(ctx, "CERT")
+	p.keyFile, _ = conf.Get(ctx, "KEY")
+	p.clientCAFile, _ = conf.Get(ctx, "CLIENTCA")
+	if p.certFile == "" || p.keyFile == "" {
+		return nil, fmt.Errorf("%w: certificate and key are required", ErrTLSConfig)
+	}
+
+	minVersionRaw, _ := conf.Get(ctx, "MINVERSION")
+	if minVersionRaw == "" {
+		minVersionRaw = "1.2"
+	}
+	minVersion, ok := tlsVersions[minVersionRaw]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown tls version %s", ErrTLSConfig, minVersionRaw)
+	}
+	p.minVersion = minVersion
+
+	ciphersRaw, _ := conf.Get(ctx, "CIPHERS")
+	ciphers, err := parseCipherSuites(ciphersRaw)
+	if err != nil {
+		return nil, err
+	}
+	p.cipherSuites = ciphers
+
+	clientAuthRaw, _ := conf.Get(ctx, "CLIENTAUTH")
+	if clientAuthRaw == "" {
+		clientAuthRaw = "none"
+	}
+	clientAuth, ok := tlsClientAuthTypes[strings.ToLower(clientAuthRaw)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown client auth mode %s", ErrTLSConfig, clientAuthRaw)
+	}
+	if clientAuth >= tls.VerifyClientCertIfGiven && p.clientCAFile == "" {
+		return nil, fmt.Errorf("%w: client auth %s requires a client CA", ErrTLSConfig, clientAuthRaw)
+	}
+	p.clientAuth = clientAuth
+
+	if reloadRaw, err := conf.Get(ctx, "RELOADINTERVAL"); err == nil && reloadRaw != "" {
+		interval, err := time.ParseDuration(reloadRaw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: could not parse reload interval: %s", ErrTLSConfig, err.Error())
+		}
+		p.reloadInterval = interval
+	}
+
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// reload reads the certificate files from disk and replaces the current
+// configuration. The previous configuration stays active on error.
+func (p *tlsProvider) reload() error {
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return fmt.Errorf("%w: could not load key pair: %s", ErrTLSConfig, err.Error())
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   p.minVersion,
+		CipherSuites: p.cipherSuites,
+		ClientAuth:   p.clientAuth,
+	}
+
+	if p.clientCAFile != "" {
+		caPem, err := os.ReadFile(p.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("%w: could not read client ca: %s", ErrTLSConfig, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPem) {
+			return fmt.Errorf("%w: no certificates found in client ca", ErrTLSConfig)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+
+	modTimes := p.statFiles()
+	p.Lock()
+	p.current = tlsConfig
+	p.modTimes = modTimes
+	p.lastCheck = time.Now()
+	p.Unlock()
+	return nil
+}
+
+func (p *tlsProvider) statFiles() (modTimes [3]time.Time) {
+	for i, file := range []string{p.certFile, p.keyFile, p.clientCAFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+// config returns the active TLS configuration. If a reload interval is set,
+// the files are checked for changes at most once per interval.
+func (p *tlsProvider) config() (*tls.Config, error) {
+	p.RLock()
+	current := p.current
+	due := p.reloadInterval > 0 && time.Since(p.lastCheck) >= p.reloadInterval
+	p.RUnlock()
+	if !due {
+		return current, nil
+	}
+
+	p.Lock()
+	p.lastCheck = time.Now()
+	changed := p.statFiles() != p.modTimes
+	p.Unlock()
+	if changed {
+		if err := p.reload(); err != nil {
+			return current, err
+		}
+	}
+	p.RLock()
+	defer p.RUnlock()
+	return p.current, nil
+}
+
+func parseCipherSuites(raw string) ([]uint16, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	var ciphers []uint16
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown cipher suite %s", ErrTLSConfig, name)
+		}
+		ciphers = append(ciphers, id)
+	}
+	return ciphers, nil
+}
+
+// initTLS creates the TLS provider of a listener. It returns nil if TLS is
+// not enabled in tlsConfig.
+func (s *Server) initTLS(ctx context.Context, tlsConfig *config.Config) (*tlsProvider, error) {
+	enabledRaw, _ := tlsConfig.Get(ctx, "ENABLED")
+	if enabled, _ := strconv.ParseBool(enabledRaw); !enabled {
+		return nil, nil
+	}
+	provider, err := newTLSProvider(ctx, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.Logger.Info(ctx, "TLS enabled with certificate %s", provider.certFile)
+	return provider, nil
+}
+
+// ReloadTLS re-reads the TLS certificates, keys and client CAs of all
+// listeners from disk. New connections use the reloaded configuration,
+// established connections are not affected.
+func (s *Server) ReloadTLS(ctx context.Context) error {
+	reloaded := 0
+	for _, l := range s.currentListeners() {
+		provider := l.tls.Load()
+		if provider == nil {
+			continue
+		}
+		if err := provider.reload(); err != nil {
+			return fmt.Errorf("could not reload tls of listener %s: %w", l.name, err)
+		}
+		reloaded++
+	}
+	if reloaded == 0 {
+		return ErrTLSDisabled
+	}
+	s.Logger.Info(ctx, "TLS certificates reloaded")
+	return nil
+}
+
+func (s *Server) handshakeTLS(ctx context.Context, provider *tlsProvider, conn net.Conn) (*tls.Conn, error) {
+	tlsConfig, err := provider.config()
+	if err != nil {
+		s.Logger.Error(ctx, "could not reload tls certificates: %s", err.Error())
+	}
+	tlsConn := tls.Server(conn, tlsConfig)
+	hsCtx, cancel := context.WithTimeout(ctx, s.ioTimeout())
+	defer cancel()
+	if err := tlsConn.HandshakeContext(hsCtx); err != nil {
+		return nil, fmt.Errorf("tls handshake failed: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// TLSConnectionState returns the state of the TLS connection the handler is
+// serving. It returns false if the connection is not using TLS.
+func TLSConnectionState(ctx context.Context) (tls.ConnectionState, bool) {
+	info, ok := ConnFromContext(ctx)
+	if !ok || info.TLS == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *info.TLS, true
+}
+
+// PeerCertificate returns the verified certificate presented by the client.
+// It returns false if the client did not present a certificate or the
+// certificate was not verified against the configured client CA.
+func PeerCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	state, ok := TLSConnectionState(ctx)
+	if !ok || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	return state.VerifiedChains[0][0], true
+}
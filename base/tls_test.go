@@ -0,0 +1,233 @@
+package base
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPem []byte
+	keyPem  []byte
+}
+
+func generateTestCert(t *testing.T, commonName string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP(tADDRESS)},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPem:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, c.certPem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, c.keyPem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(c.certPem, c.keyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func startTLSServer(t *testing.T, tlsOptions map[string]interface{}, handle HandleFunc) *Server {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+		"TLS":     tlsOptions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := server.Serve(ctx, handle); err != nil {
+			panic(err)
+		}
+	}()
+	return server
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := generateTestCert(t, "test-ca", nil, true)
+	serverCert := generateTestCert(t, "server", ca, false)
+	certFile, keyFile := serverCert.write(t, dir, "server")
+
+	server := startTLSServer(t, map[string]interface{}{
+		"ENABLED": true,
+		"CERT":    certFile,
+		"KEY":     keyFile,
+	}, testHandle)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", server.Addrs()[0].String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buff, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testMsg, buff)
+}
+
+func TestServeMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := generateTestCert(t, "test-ca", nil, true)
+	serverCert := generateTestCert(t, "server", ca, false)
+	clientCert := generateTestCert(t, "client", ca, false)
+	certFile, keyFile := serverCert.write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+
+	peers := make(chan string, 1)
+	server := startTLSServer(t, map[string]interface{}{
+		"ENABLED":    true,
+		"CERT":       certFile,
+		"KEY":        keyFile,
+		"CLIENTCA":   caFile,
+		"CLIENTAUTH": "required",
+	}, func(ctx context.Context, conn net.Conn) error {
+		if cert, ok := PeerCertificate(ctx); ok {
+			peers <- cert.Subject.CommonName
+		} else {
+			peers <- ""
+		}
+		return testHandle(ctx, conn)
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	addr := server.Addrs()[0].String()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert.tlsCertificate(t)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buff, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testMsg, buff)
+	assert.Equal(t, "client", <-peers)
+
+	// without a client certificate the handshake must fail
+	anonymous, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots})
+	if err == nil {
+		defer anonymous.Close()
+		_, err = io.ReadAll(anonymous)
+	}
+	assert.Error(t, err)
+}
+
+func TestReloadTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := generateTestCert(t, "test-ca", nil, true)
+	first := generateTestCert(t, "first", ca, false)
+	certFile, keyFile := first.write(t, dir, "server")
+
+	server := startTLSServer(t, map[string]interface{}{
+		"ENABLED": true,
+		"CERT":    certFile,
+		"KEY":     keyFile,
+	}, testHandle)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	addr := server.Addrs()[0].String()
+	servedName := func() string {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	assert.Equal(t, "first", servedName())
+
+	second := generateTestCert(t, "second", ca, false)
+	second.write(t, dir, "server")
+	if err := server.ReloadTLS(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "second", servedName())
+}
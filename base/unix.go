@@ -0,0 +1,166 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+)
+
+var (
+	// ErrSocketInUse indicates that another process is listening on the
+	// configured unix socket.
+	ErrSocketInUse = errors.New("unix socket is in use")
+
+	// ErrNotASocket indicates that the configured unix socket path exists but
+	// is not a socket.
+	ErrNotASocket = errors.New("path exists and is not a socket")
+
+	// ErrPeerCredentialsUnsupported indicates that peer credentials are not
+	// available on this platform.
+	ErrPeerCredentialsUnsupported = errors.New("peer credentials are not supported on this platform")
+)
+
+// PeerCredentials are the credentials of the process connected to a unix
+// socket, as reported by the kernel when the connection was established.
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// PeerCredentialsFromContext returns the credentials of the peer process
+// of a unix socket connection.
+func PeerCredentialsFromContext(ctx context.Context) (PeerCredentials, bool) {
+	info, ok := ConnFromContext(ctx)
+	if !ok || info.PeerCredentials == nil {
+		return PeerCredentials{}, false
+	}
+	return *info.PeerCredentials, true
+}
+
+func isUnixNetwork(network string) bool {
+	switch network {
+	case "unix", "unixpacket", "unixgram":
+		return true
+	default:
+		return false
+	}
+}
+
+// prepareUnixSocket resolves the socket path of l and removes a stale socket
+// file left behind by a previous process.
+func (s *Server) prepareUnixSocket(ctx context.Context, l *listener, unixConfig *config.Config, address string) error {
+	socketPath, _ := unixConfig.Get(ctx, "PATH")
+	if socketPath == "" {
+		socketPath = address
+	}
+
+	info, err := os.Lstat(socketPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		l.socketPath = socketPath
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%w: %s", ErrNotASocket, socketPath)
+	}
+
+	removeStaleRaw, _ := unixConfig.Get(ctx, "REMOVESTALE")
+	if removeStale, _ := strconv.ParseBool(removeStaleRaw); !removeStale {
+		return fmt.Errorf("%w: %s", ErrSocketInUse, socketPath)
+	}
+	// a socket that still accepts connections belongs to a running process
+	if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%w: %s", ErrSocketInUse, socketPath)
+	}
+	if err := os.Remove(socketPath); err != nil {
+		return fmt.Errorf("could not remove stale socket: %w", err)
+	}
+	s.Logger.Info(ctx, "Removed stale socket %s", socketPath)
+	l.socketPath = socketPath
+	return nil
+}
+
+// applySocketPermissions sets the configured mode and ownership of the
+// socket file.
+func applySocketPermissions(ctx context.Context, socketPath string, unixConfig *config.Config) error {
+	var err error
+	if modeRaw, _ := unixConfig.Get(ctx, "MODE"); modeRaw != "" {
+		mode, err := strconv.ParseUint(modeRaw, 8, 32)
+		if err != nil {
+			return fmt.Errorf("could not parse socket mode: %w", err)
+		}
+		if err := os.Chmod(socketPath, fs.FileMode(mode)); err != nil {
+			return fmt.Errorf("could not set socket mode: %w", err)
+		}
+	}
+
+	owner, _ := unixConfig.Get(ctx, "OWNER")
+	group, _ := unixConfig.Get(ctx, "GROUP")
+	if owner == "" && group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if owner != "" {
+		if uid, err = lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return fmt.Errorf("could not resolve socket owner: %w", err)
+		}
+	}
+	if group != "" {
+		if gid, err = lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return fmt.Errorf("could not resolve socket group: %w", err)
+		}
+	}
+	if err := os.Lchown(socketPath, uid, gid); err != nil {
+		return fmt.Errorf("could not set socket owner: %w", err)
+	}
+	return nil
+}
+
+// lookupID accepts a numeric id or resolves a name using lookup.
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	idRaw, err := lookup(nameOrID)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(idRaw)
+}
+
+// removeUnixSocket removes the socket file once the listener is closed.
+func (l *listener) removeUnixSocket() error {
+	var err error
+	l.socketCleanup.Do(func() {
+		if l.socketPath == "" {
+			return
+		}
+		if rmErr := os.Remove(l.socketPath); rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) {
+			err = rmErr
+		}
+	})
+	return err
+}
@@ -0,0 +1,111 @@
+package base
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func startUnixServer(t *testing.T, socketPath string, handle HandleFunc) (*Server, <-chan error) {
+	t.Helper()
+	ctx := context.Background()
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"TYPE": "unix",
+		"UNIX": map[string]interface{}{
+			"PATH": socketPath,
+			"MODE": "0600",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, handle)
+	}()
+	return server, served
+}
+
+func TestServeUnix(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "pepper.sock")
+	creds := make(chan PeerCredentials, 1)
+	server, served := startUnixServer(t, socketPath, func(ctx context.Context, conn net.Conn) error {
+		cred, _ := PeerCredentialsFromContext(ctx)
+		creds <- cred
+		return testHandle(ctx, conn)
+	})
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buff, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testMsg, buff)
+
+	cred := <-creds
+	assert.Equal(t, int32(os.Getpid()), cred.PID)
+	assert.Equal(t, uint32(os.Getuid()), cred.UID)
+	assert.Equal(t, uint32(os.Getgid()), cred.GID)
+
+	if err := server.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, <-served)
+	_, err = os.Stat(socketPath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestUnixRemovesStaleSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "stale.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	server, served := startUnixServer(t, socketPath, testHandle)
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// a second server must not steal the socket of a running one
+	second := &Server{}
+	conf, err := config.WithInitialValues(context.Background(), map[string]interface{}{
+		"TYPE": "unix",
+		"UNIX": map[string]interface{}{
+			"PATH": socketPath,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(t, second.Listen(context.Background(), conf), ErrSocketInUse)
+
+	if err := server.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, <-served)
+}
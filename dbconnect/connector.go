@@ -16,6 +16,7 @@ import (
 	_ "github.com/microsoft/go-mssqldb"
 	"github.com/myLogic207/gotils/config"
 	log "github.com/myLogic207/gotils/logger"
+	"github.com/myLogic207/pepper/metrics"
 )
 
 type DBContextKey string
@@ -62,8 +63,12 @@ var dbTypeLookup = map[string]urlGenerator{
 
 type DB struct {
 	*sql.DB
-	conf   *config.Config
-	logger log.Logger
+	// Metrics receives the pool, transaction and query metrics if set
+	// before Connect, see EnableMetrics.
+	Metrics *metrics.Registry
+	metrics dbMetrics
+	conf    *config.Config
+	logger  log.Logger
 }
 
 func NewWithConnector(ctx context.Context, connector *sql.DB, logger log.Logger) (*DB, error) {
@@ -105,6 +110,11 @@ func (db *DB) Connect(ctx context.Context, options *config.Config) error {
 	}
 
 	db.DB = conn
+	if db.Metrics != nil {
+		if err := db.EnableMetrics(ctx, db.Metrics); err != nil {
+			return fmt.Errorf("could not register metrics: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -139,6 +149,7 @@ type TransactionFunc func(tx *sql.Tx) error
 func (db *DB) Transaction(ctx context.Context, transaction TransactionFunc, options *sql.TxOptions) error {
 	tx, err := db.BeginTx(ctx, options)
 	if err != nil {
+		db.metrics.transaction(txOutcomeError)
 		return err
 	}
 	db.logger.Debug(ctx, "transaction started")
@@ -146,14 +157,18 @@ func (db *DB) Transaction(ctx context.Context, transaction TransactionFunc, opti
 	if err := transaction(tx); err != nil {
 		db.logger.Error(ctx, err.Error())
 		if err := tx.Rollback(); err != nil {
+			db.metrics.transaction(txOutcomeError)
 			return err
 		}
+		db.metrics.transaction(txOutcomeRollback)
 		return err
 	}
 
 	if err := tx.Commit(); err != nil {
+		db.metrics.transaction(txOutcomeError)
 		return err
 	}
+	db.metrics.transaction(txOutcomeCommit)
 	db.logger.Debug(ctx, "transaction committed")
 
 	return nil
@@ -292,3 +307,13 @@ func newMssqlConnector(ctx context.Context, conf *config.Config) (url string, er
 
 	return dsn, nil
 }
+
+// Type returns the configured database type, it is empty for databases
+// created with NewWithConnector.
+func (db *DB) Type(ctx context.Context) string {
+	if db.conf == nil {
+		return ""
+	}
+	dbType, _ := db.conf.Get(ctx, "TYPE")
+	return dbType
+}
@@ -0,0 +1,120 @@
+package dbconnect
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/myLogic207/pepper/metrics"
+)
+
+const (
+	txOutcomeCommit   = "commit"
+	txOutcomeRollback = "rollback"
+	txOutcomeError    = "error"
+)
+
+// dbMetrics are the instruments of a database. All of them are nil until
+// EnableMetrics is called.
+type dbMetrics struct {
+	transactions *metrics.CounterVec
+	query        *metrics.Histogram
+	exec         *metrics.Histogram
+	name         string
+}
+
+// EnableMetrics registers the pool statistics, transaction outcomes and
+// query latencies of the database with registry. The database is labeled
+// with its configured NAME. Connect calls it if DB.Metrics is set.
+func (db *DB) EnableMetrics(ctx context.Context, registry *metrics.Registry) error {
+	name := "default"
+	if db.conf != nil {
+		if configured, _ := db.conf.Get(ctx, "NAME"); configured != "" {
+			name = configured
+		}
+	}
+	m := dbMetrics{name: name}
+
+	pool, err := registry.GaugeVec("pepper_db_pool_connections", "Pool connections per state.", "db", "state")
+	if err != nil {
+		return err
+	}
+	stat := func(read func(sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			return read(db.Stats())
+		}
+	}
+	pool.Func(stat(func(s sql.DBStats) float64 { return float64(s.InUse) }), name, "in_use")
+	pool.Func(stat(func(s sql.DBStats) float64 { return float64(s.Idle) }), name, "idle")
+	pool.Func(stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }), name, "max_open")
+	waits, err := registry.CounterVec("pepper_db_pool_waits_total", "Connections waited for because the pool was exhausted.", "db")
+	if err != nil {
+		return err
+	}
+	waits.Func(stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }), name)
+	waited, err := registry.CounterVec("pepper_db_pool_wait_seconds_total", "Time spent waiting for a pool connection.", "db")
+	if err != nil {
+		return err
+	}
+	waited.Func(stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }), name)
+	closed, err := registry.CounterVec("pepper_db_pool_closed_total", "Pool connections closed per reason.", "db", "reason")
+	if err != nil {
+		return err
+	}
+	closed.Func(stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }), name, "max_idle")
+	closed.Func(stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }), name, "max_idle_time")
+	closed.Func(stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }), name, "max_lifetime")
+
+	if m.transactions, err = registry.CounterVec("pepper_db_transactions_total", "Transactions per outcome.", "db", "outcome"); err != nil {
+		return err
+	}
+	latency, err := registry.HistogramVec("pepper_db_query_duration_seconds", "Latency of queries and statements.", nil, "db", "operation")
+	if err != nil {
+		return err
+	}
+	m.query = latency.With(name, "query")
+	m.exec = latency.With(name, "exec")
+
+	db.Metrics = registry
+	db.metrics = m
+	return nil
+}
+
+func (m *dbMetrics) transaction(outcome string) {
+	m.transactions.With(m.name, outcome).Inc()
+}
+
+func observeSince(histogram *metrics.Histogram, start time.Time) {
+	histogram.Observe(time.Since(start).Seconds())
+}
+
+// QueryContext runs a query and records its latency.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer observeSince(db.metrics.query, time.Now())
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs a query returning at most one row and records its
+// latency.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer observeSince(db.metrics.query, time.Now())
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+// ExecContext runs a statement and records its latency.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer observeSince(db.metrics.exec, time.Now())
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+func (db *DB) QueryRow(query string, args ...any) *sql.Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
@@ -0,0 +1,71 @@
+package dbconnect
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/myLogic207/gotils/config"
+	log "github.com/myLogic207/gotils/logger"
+	"github.com/myLogic207/pepper/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	loggerConfig, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"PREFIX": "DATABASE-TEST",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := log.Init(ctx, loggerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector.SetMaxOpenConns(1)
+	db, err := NewWithConnector(ctx, connector, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DB.Close()
+
+	registry := metrics.NewRegistry()
+	if err := db.EnableMetrics(ctx, registry); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE test (id INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.Transaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO test (id) VALUES (1)")
+		return err
+	}, nil))
+	errRollback := errors.New("rollback")
+	assert.ErrorIs(t, db.Transaction(ctx, func(tx *sql.Tx) error {
+		return errRollback
+	}, nil), errRollback)
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM test").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, count)
+
+	buff := &bytes.Buffer{}
+	if _, err := registry.WriteTo(buff); err != nil {
+		t.Fatal(err)
+	}
+	exposed := buff.String()
+	assert.Contains(t, exposed, `pepper_db_transactions_total{db="default",outcome="commit"} 1`)
+	assert.Contains(t, exposed, `pepper_db_transactions_total{db="default",outcome="rollback"} 1`)
+	assert.Contains(t, exposed, `pepper_db_query_duration_seconds_count{db="default",operation="exec"} 1`)
+	assert.Contains(t, exposed, `pepper_db_query_duration_seconds_count{db="default",operation="query"} 1`)
+	assert.Contains(t, exposed, `pepper_db_pool_connections{db="default",state="max_open"} 1`)
+}
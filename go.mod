@@ -1,6 +1,6 @@
 module github.com/myLogic207/pepper
 
-go 1.21.4
+go 1.23.0
 
 require (
 	github.com/Masterminds/squirrel v1.5.4
@@ -10,8 +10,8 @@ require (
 	github.com/microsoft/go-mssqldb v1.6.0
 	github.com/myLogic207/gotils v0.1.6
 	github.com/stretchr/testify v1.8.4
-	golang.org/x/crypto v0.17.0
-	golang.org/x/sync v0.5.0
+	golang.org/x/crypto v0.35.0
+	golang.org/x/sync v0.11.0
 )
 
 require (
@@ -26,7 +26,7 @@ require (
 	github.com/lann/ps v0.0.0-20150810152359-62de8c46ede0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.6.1 // indirect
-	golang.org/x/sys v0.15.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
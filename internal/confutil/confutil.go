@@ -0,0 +1,64 @@
+// Package confutil holds helpers to walk gotils configs shared by the
+// servers of this module.
+package confutil
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/myLogic207/gotils/config"
+)
+
+// Section returns the nested config at key, nil if it holds no values.
+// GetConfig returns an empty config for missing keys instead of an error.
+func Section(ctx context.Context, conf *config.Config, key string) *config.Config {
+	section, err := conf.GetConfig(ctx, key)
+	if err != nil || section == nil || len(section.Keys(ctx)) == 0 {
+		return nil
+	}
+	return section
+}
+
+// Keys returns the dotted paths of all values in a config map like the
+// default configs of the servers, sorted.
+func Keys(values map[string]interface{}) []string {
+	var keys []string
+	for key, value := range values {
+		if nested, ok := value.(map[string]interface{}); ok {
+			for _, nestedKey := range Keys(nested) {
+				keys = append(keys, key+"."+nestedKey)
+			}
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Value returns the value at a dotted path, or an empty string if it is
+// not set.
+func Value(ctx context.Context, conf *config.Config, key string) string {
+	path := strings.Split(key, ".")
+	for _, section := range path[:len(path)-1] {
+		nested, err := conf.GetConfig(ctx, section)
+		if err != nil || nested == nil {
+			return ""
+		}
+		conf = nested
+	}
+	value, _ := conf.Get(ctx, path[len(path)-1])
+	return value
+}
+
+// Changed returns the keys whose values differ between old and next.
+func Changed(ctx context.Context, old, next *config.Config, keys []string) []string {
+	var changed []string
+	for _, key := range keys {
+		if Value(ctx, old, key) != Value(ctx, next, key) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,60 @@
+package confutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSection(t *testing.T) {
+	ctx := context.Background()
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"LISTENERS": map[string]interface{}{
+			"0": map[string]interface{}{
+				"NAME": "first",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listeners := Section(ctx, conf, "LISTENERS")
+	if listeners == nil {
+		t.Fatal("missing listeners section")
+	}
+	assert.NotNil(t, Section(ctx, listeners, "0"))
+	assert.Nil(t, Section(ctx, listeners, "1"))
+	assert.Nil(t, Section(ctx, conf, "MISSING"))
+}
+
+func TestChanged(t *testing.T) {
+	ctx := context.Background()
+	defaults := map[string]interface{}{
+		"KEY": "",
+		"LOGGER": map[string]interface{}{
+			"LEVEL":  "info",
+			"PREFIX": "test",
+		},
+	}
+	assert.Equal(t, []string{"KEY", "LOGGER.LEVEL", "LOGGER.PREFIX"}, Keys(defaults))
+
+	old, err := config.WithInitialValues(ctx, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"KEY": "changed",
+		"LOGGER": map[string]interface{}{
+			"LEVEL":  "info",
+			"PREFIX": "other",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "other", Value(ctx, next, "LOGGER.PREFIX"))
+	assert.Equal(t, "", Value(ctx, next, "MISSING.VALUE"))
+	assert.Equal(t, []string{"KEY", "LOGGER.PREFIX"}, Changed(ctx, old, next, Keys(defaults)))
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// shutdownTimeout bounds the time ListenAndServe waits for running scrapes.
+const shutdownTimeout = 5 * time.Second
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// WriteTo writes all metrics in the Prometheus text format, ordered by name
+// and label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	counter := &countingWriter{w: w}
+	buffered := bufio.NewWriter(counter)
+	for _, f := range families {
+		f.write(buffered)
+	}
+	err := buffered.Flush()
+	return counter.n, err
+}
+
+func (f *family) write(w *bufio.Writer) {
+	all := f.sortedSeries()
+	if len(all) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, helpEscaper.Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range all {
+		if f.kind != KindHistogram {
+			value := s.value.load()
+			if fn := s.fn.Load(); fn != nil {
+				value = (*fn)()
+			}
+			writeSample(w, f.name, f.labelNames, s.labelValues, "", value)
+			continue
+		}
+
+		s.histMu.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		sum, samples := s.sum, s.samples
+		s.histMu.Unlock()
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += counts[i]
+			writeSample(w, f.name+"_bucket", f.labelNames, s.labelValues, formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, f.name+"_bucket", f.labelNames, s.labelValues, "+Inf", float64(samples))
+		writeSample(w, f.name+"_sum", f.labelNames, s.labelValues, "", sum)
+		writeSample(w, f.name+"_count", f.labelNames, s.labelValues, "", float64(samples))
+	}
+}
+
+// writeSample writes a single line, le is added as last label of histogram
+// buckets.
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, le string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || le != "" {
+		w.WriteByte('{')
+		for i, label := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, labelEscaper.Replace(labelValues[i]))
+		}
+		if le != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `le="%s"`, le)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		if req.Method == http.MethodHead {
+			return
+		}
+		r.WriteTo(w)
+	})
+}
+
+// ListenAndServe serves the registry on address under /metrics until ctx
+// is done.
+func ListenAndServe(ctx context.Context, address string, registry *Registry) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: shutdownTimeout,
+	}
+	stopped := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		stopped <- server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-stopped
+}
@@ -0,0 +1,343 @@
+// Package metrics collects counters, gauges and histograms and exposes them
+// in the Prometheus text format.
+//
+// All methods are safe to call on nil vectors and metrics, so instrumented
+// code does not need to check whether metrics are enabled.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrMetricConflict indicates that a metric was registered again with a
+	// different type, help text or labels.
+	ErrMetricConflict = errors.New("metric already registered with different options")
+	// ErrInvalidName indicates a metric or label name that is not allowed
+	// in the Prometheus text format.
+	ErrInvalidName = errors.New("invalid metric name")
+)
+
+// Kind is the type of a metric family.
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// DefaultBuckets are histogram buckets for latencies in seconds.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry holds metric families. Registering a family that already exists
+// with the same options returns the existing one, so multiple servers can
+// share a registry.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type family struct {
+	name       string
+	help       string
+	kind       Kind
+	labelNames []string
+	buckets    []float64
+	mu         sync.RWMutex
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       atomicFloat
+	// fn replaces value, it is read whenever the metric is exposed
+	fn atomic.Pointer[func() float64]
+	// histogram state
+	histMu  sync.Mutex
+	counts  []uint64
+	sum     float64
+	samples uint64
+}
+
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(value float64) {
+	f.bits.Store(math.Float64bits(value))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Registry) family(name, help string, kind Kind, buckets []float64, labelNames []string) (*family, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidName, name)
+	}
+	for _, label := range labelNames {
+		if !validName(label) || strings.Contains(label, ":") || label == "le" {
+			return nil, fmt.Errorf("%w: label %s of %s", ErrInvalidName, label, name)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.families[name]; ok {
+		if existing.kind != kind || existing.help != help || !slices.Equal(existing.labelNames, labelNames) || !slices.Equal(existing.buckets, buckets) {
+			return nil, fmt.Errorf("%w: %s", ErrMetricConflict, name)
+		}
+		return existing, nil
+	}
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: slices.Clone(labelNames),
+		buckets:    slices.Clone(buckets),
+		series:     make(map[string]*series),
+	}
+	r.families[name] = f
+	return f, nil
+}
+
+// with returns the series for the label values, creating it if needed. A
+// wrong number of label values is a programming error and panics.
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok = f.series[key]; ok {
+		return s
+	}
+	s = &series{labelValues: slices.Clone(labelValues)}
+	if f.kind == KindHistogram {
+		s.counts = make([]uint64, len(f.buckets))
+	}
+	f.series[key] = s
+	return s
+}
+
+// sortedSeries returns the series ordered by their label values.
+func (f *family) sortedSeries() []*series {
+	f.mu.RLock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		return slices.Compare(all[i].labelValues, all[j].labelValues) < 0
+	})
+	return all
+}
+
+// CounterVec is a family of counters partitioned by labels.
+type CounterVec struct {
+	f *family
+}
+
+// CounterVec registers a counter family. A nil registry returns a nil
+// vector that discards all values.
+func (r *Registry) CounterVec(name, help string, labelNames ...string) (*CounterVec, error) {
+	if r == nil {
+		return nil, nil
+	}
+	f, err := r.family(name, help, KindCounter, nil, labelNames)
+	if err != nil {
+		return nil, err
+	}
+	return &CounterVec{f: f}, nil
+}
+
+// With returns the counter for the given label values.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	if v == nil {
+		return nil
+	}
+	return &Counter{s: v.f.with(labelValues)}
+}
+
+// Func exposes the value returned by fn, which must never decrease, as the
+// counter for the given label values.
+func (v *CounterVec) Func(fn func() float64, labelValues ...string) {
+	if v == nil {
+		return
+	}
+	v.f.with(labelValues).fn.Store(&fn)
+}
+
+// Counter is a value that only increases.
+type Counter struct {
+	s *series
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter, negative values are ignored.
+func (c *Counter) Add(delta float64) {
+	if c == nil || delta < 0 {
+		return
+	}
+	c.s.value.add(delta)
+}
+
+// GaugeVec is a family of gauges partitioned by labels.
+type GaugeVec struct {
+	f *family
+}
+
+// GaugeVec registers a gauge family. A nil registry returns a nil vector
+// that discards all values.
+func (r *Registry) GaugeVec(name, help string, labelNames ...string) (*GaugeVec, error) {
+	if r == nil {
+		return nil, nil
+	}
+	f, err := r.family(name, help, KindGauge, nil, labelNames)
+	if err != nil {
+		return nil, err
+	}
+	return &GaugeVec{f: f}, nil
+}
+
+// With returns the gauge for the given label values.
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	if v == nil {
+		return nil
+	}
+	return &Gauge{s: v.f.with(labelValues)}
+}
+
+// Func exposes the value returned by fn as the gauge for the given label
+// values.
+func (v *GaugeVec) Func(fn func() float64, labelValues ...string) {
+	if v == nil {
+		return
+	}
+	v.f.with(labelValues).fn.Store(&fn)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	s *series
+}
+
+func (g *Gauge) Set(value float64) {
+	if g == nil {
+		return
+	}
+	g.s.value.set(value)
+}
+
+func (g *Gauge) Add(delta float64) {
+	if g == nil {
+		return
+	}
+	g.s.value.add(delta)
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// HistogramVec is a family of histograms partitioned by labels.
+type HistogramVec struct {
+	f *family
+}
+
+// HistogramVec registers a histogram family with the given upper bucket
+// bounds, DefaultBuckets are used if buckets is empty. A nil registry
+// returns a nil vector that discards all values.
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labelNames ...string) (*HistogramVec, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	if !slices.IsSorted(buckets) {
+		return nil, fmt.Errorf("buckets of %s are not sorted", name)
+	}
+	f, err := r.family(name, help, KindHistogram, buckets, labelNames)
+	if err != nil {
+		return nil, err
+	}
+	return &HistogramVec{f: f}, nil
+}
+
+// With returns the histogram for the given label values.
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	if v == nil {
+		return nil
+	}
+	return &Histogram{s: v.f.with(labelValues), buckets: v.f.buckets}
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+func (h *Histogram) Observe(value float64) {
+	if h == nil {
+		return
+	}
+	h.s.histMu.Lock()
+	defer h.s.histMu.Unlock()
+	if i, _ := slices.BinarySearch(h.buckets, value); i < len(h.buckets) {
+		h.s.counts[i]++
+	}
+	h.s.sum += value
+	h.s.samples++
+}
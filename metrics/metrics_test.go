@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTo(t *testing.T) {
+	registry := NewRegistry()
+	requests, err := registry.CounterVec("test_requests_total", "Requests by method.", "method")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests.With("GET").Inc()
+	requests.With("GET").Add(2)
+	requests.With("POST").Inc()
+
+	active, err := registry.GaugeVec("test_active", "Active \"things\".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	active.Func(func() float64 { return 7 })
+
+	latency, err := registry.HistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	latency.With().Observe(0.05)
+	latency.With().Observe(0.5)
+	latency.With().Observe(5)
+
+	buff := &bytes.Buffer{}
+	if _, err := registry.WriteTo(buff); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `# HELP test_active Active "things".
+# TYPE test_active gauge
+test_active 7
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 1
+test_latency_seconds_bucket{le="1"} 2
+test_latency_seconds_bucket{le="+Inf"} 3
+test_latency_seconds_sum 5.55
+test_latency_seconds_count 3
+# HELP test_requests_total Requests by method.
+# TYPE test_requests_total counter
+test_requests_total{method="GET"} 3
+test_requests_total{method="POST"} 1
+`, buff.String())
+}
+
+func TestRegisterTwice(t *testing.T) {
+	registry := NewRegistry()
+	first, err := registry.CounterVec("test_total", "Test.", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := registry.CounterVec("test_total", "Test.", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.With("a").Inc()
+	second.With("a").Inc()
+
+	buff := &bytes.Buffer{}
+	registry.WriteTo(buff)
+	assert.Contains(t, buff.String(), `test_total{server="a"} 2`)
+
+	_, err = registry.GaugeVec("test_total", "Test.", "server")
+	assert.ErrorIs(t, err, ErrMetricConflict)
+	_, err = registry.CounterVec("test-total", "Test.")
+	assert.ErrorIs(t, err, ErrInvalidName)
+}
+
+func TestNilRegistry(t *testing.T) {
+	var registry *Registry
+	counter, err := registry.CounterVec("test_total", "Test.")
+	assert.NoError(t, err)
+	counter.With().Inc()
+	gauge, _ := registry.GaugeVec("test_gauge", "Test.")
+	gauge.With().Set(1)
+	histogram, _ := registry.HistogramVec("test_histogram", "Test.", nil)
+	histogram.With().Observe(1)
+}
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	counter, _ := registry.CounterVec("test_total", "Test.", "label")
+	counter.With("line\nbreak").Inc()
+
+	server := httptest.NewServer(registry.Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), `test_total{label="line\nbreak"} 1`)
+}
@@ -0,0 +1,242 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/myLogic207/pepper/internal/confutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// The authentication methods an AuthPolicy can require.
+const (
+	MethodPublicKey           = "publickey"
+	MethodPassword            = "password"
+	MethodKeyboardInteractive = "keyboard-interactive"
+)
+
+// ErrUnknownAuthMethod indicates an auth policy with an unsupported method.
+var ErrUnknownAuthMethod = errors.New("unknown authentication method")
+
+// AuthPolicy lists the methods a user has to pass to log in, e.g.
+// publickey and keyboard-interactive for privileged users. An empty policy
+// accepts any single enabled method. keyboard-interactive asking for a TOTP
+// code is never accepted as first method.
+type AuthPolicy struct {
+	Methods []string
+	// Ordered requires the methods in the listed order, otherwise they may
+	// be passed in any order.
+	Ordered bool
+}
+
+// AuthPolicyProvider returns the AuthPolicy of users.
+type AuthPolicyProvider interface {
+	AuthPolicy(ctx context.Context, user string) (AuthPolicy, error)
+}
+
+// authProgress is the state of the authentication of a connection, it is
+// carried to the next step in the callbacks of ssh.PartialSuccessError.
+type authProgress struct {
+	policy       AuthPolicy
+	secondFactor bool
+	passed       []string
+	permissions  *ssh.Permissions
+}
+
+// next returns the methods allowed for the next step.
+func (ap *authProgress) next() []string {
+	var remaining []string
+	if len(ap.policy.Methods) == 0 {
+		if len(ap.passed) == 0 {
+			remaining = []string{MethodPublicKey, MethodPassword, MethodKeyboardInteractive}
+		}
+	} else {
+		for _, method := range ap.policy.Methods {
+			if !slices.Contains(ap.passed, method) {
+				remaining = append(remaining, method)
+			}
+		}
+		if ap.policy.Ordered && len(remaining) > 0 {
+			remaining = remaining[:1]
+		}
+	}
+	if ap.secondFactor && len(ap.passed) == 0 {
+		remaining = slices.DeleteFunc(remaining, func(method string) bool {
+			return method == MethodKeyboardInteractive
+		})
+	}
+	return remaining
+}
+
+// authStep runs verify for method if the policy of the user allows it at
+// this step. progress is nil for the first step. Once all required methods
+// passed, the merged permissions are returned, a *ssh.PartialSuccessError
+// offering the remaining methods before.
+func (s *Server) authStep(conn ssh.ConnMetadata, progress *authProgress, method string, verify func() (*ssh.Permissions, error)) (*ssh.Permissions, error) {
+	auth := s.authSettings()
+	if progress == nil {
+		policy, err := auth.policies.AuthPolicy(context.Background(), conn.User())
+		if err != nil {
+			return nil, ErrAuthFailedReason{err}
+		}
+		progress = &authProgress{policy: policy, secondFactor: auth.secondFactor}
+	}
+	if !slices.Contains(progress.next(), method) {
+		return nil, ErrAuthFailedReason{fmt.Errorf("%s is not allowed at this step", method)}
+	}
+	permissions, err := verify()
+	if err != nil {
+		return nil, err
+	}
+	next := &authProgress{
+		policy:       progress.policy,
+		secondFactor: progress.secondFactor,
+		passed:       append(slices.Clone(progress.passed), method),
+		permissions:  mergePermissions(progress.permissions, permissions),
+	}
+	if len(next.next()) == 0 {
+		return next.permissions, nil
+	}
+	return nil, &ssh.PartialSuccessError{Next: s.nextCallbacks(auth, next)}
+}
+
+// nextCallbacks offers the enabled methods allowed after progress.
+func (s *Server) nextCallbacks(auth *authSettings, progress *authProgress) ssh.ServerAuthCallbacks {
+	var callbacks ssh.ServerAuthCallbacks
+	for _, method := range progress.next() {
+		switch method {
+		case MethodPublicKey:
+			callbacks.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+				return s.authStep(conn, progress, MethodPublicKey, func() (*ssh.Permissions, error) {
+					return s.verifyPublicKey(conn, key)
+				})
+			}
+		case MethodPassword:
+			if auth.credentials != nil {
+				callbacks.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+					return s.authStep(conn, progress, MethodPassword, func() (*ssh.Permissions, error) {
+						return s.verifyPassword(conn, password)
+					})
+				}
+			}
+		case MethodKeyboardInteractive:
+			if auth.interactive != nil {
+				callbacks.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+					return s.authStep(conn, progress, MethodKeyboardInteractive, func() (*ssh.Permissions, error) {
+						return s.verifyKeyboardInteractive(conn, challenge)
+					})
+				}
+			}
+		}
+	}
+	return callbacks
+}
+
+// mergePermissions combines the permissions of the passed methods, later
+// methods win on conflicts.
+func mergePermissions(permissions ...*ssh.Permissions) *ssh.Permissions {
+	merged := &ssh.Permissions{
+		CriticalOptions: make(map[string]string),
+		Extensions:      make(map[string]string),
+	}
+	for _, p := range permissions {
+		if p == nil {
+			continue
+		}
+		for key, value := range p.CriticalOptions {
+			merged.CriticalOptions[key] = value
+		}
+		for key, value := range p.Extensions {
+			merged.Extensions[key] = value
+		}
+	}
+	return merged
+}
+
+// configPolicies are the policies of the AUTH section. METHODS and ORDERED
+// form the default policy, AUTH.POLICIES is a list keyed by index ("0",
+// "1", ...) of policies with the USERS they apply to.
+type configPolicies struct {
+	fallback AuthPolicy
+	users    map[string]AuthPolicy
+}
+
+func (cp *configPolicies) AuthPolicy(ctx context.Context, user string) (AuthPolicy, error) {
+	if policy, ok := cp.users[user]; ok {
+		return policy, nil
+	}
+	return cp.fallback, nil
+}
+
+// checkSecondFactor returns an error if a policy can only be started with
+// keyboard-interactive, which no user could pass when it asks for TOTP.
+func (cp *configPolicies) checkSecondFactor() error {
+	policies := []AuthPolicy{cp.fallback}
+	for _, policy := range cp.users {
+		policies = append(policies, policy)
+	}
+	for _, policy := range policies {
+		progress := &authProgress{policy: policy, secondFactor: true}
+		if len(progress.next()) == 0 {
+			return fmt.Errorf("keyboard-interactive can not be the first method of %s", strings.Join(policy.Methods, ","))
+		}
+	}
+	return nil
+}
+
+func parseAuthPolicy(ctx context.Context, conf *config.Config) (AuthPolicy, error) {
+	var policy AuthPolicy
+	methodsRaw, _ := conf.Get(ctx, "METHODS")
+	for _, method := range strings.Split(methodsRaw, ",") {
+		method = strings.TrimSpace(method)
+		if method == "" {
+			continue
+		}
+		if method != MethodPublicKey && method != MethodPassword && method != MethodKeyboardInteractive {
+			return AuthPolicy{}, fmt.Errorf("%w: %s", ErrUnknownAuthMethod, method)
+		}
+		if !slices.Contains(policy.Methods, method) {
+			policy.Methods = append(policy.Methods, method)
+		}
+	}
+	ordered, _ := conf.Get(ctx, "ORDERED")
+	policy.Ordered = ordered == "true"
+	return policy, nil
+}
+
+func loadAuthPolicies(ctx context.Context, conf *config.Config) (*configPolicies, error) {
+	policies := &configPolicies{users: make(map[string]AuthPolicy)}
+	authConfig, err := conf.GetConfig(ctx, "AUTH")
+	if err != nil || authConfig == nil {
+		return policies, nil
+	}
+	if policies.fallback, err = parseAuthPolicy(ctx, authConfig); err != nil {
+		return nil, ErrSSHConfigReason{err}
+	}
+	entries := confutil.Section(ctx, authConfig, "POLICIES")
+	if entries == nil {
+		return policies, nil
+	}
+	for i := 0; ; i++ {
+		entry := confutil.Section(ctx, entries, strconv.Itoa(i))
+		if entry == nil {
+			break
+		}
+		policy, err := parseAuthPolicy(ctx, entry)
+		if err != nil {
+			return nil, ErrSSHConfigReason{fmt.Errorf("policy %d: %w", i, err)}
+		}
+		users, _ := entry.Get(ctx, "USERS")
+		for _, user := range strings.Split(users, ",") {
+			if user = strings.TrimSpace(user); user != "" {
+				policies.users[user] = policy
+			}
+		}
+	}
+	return policies, nil
+}
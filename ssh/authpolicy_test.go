@@ -0,0 +1,182 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+type staticPolicies map[string]AuthPolicy
+
+func (sp staticPolicies) AuthPolicy(ctx context.Context, user string) (AuthPolicy, error) {
+	return sp[user], nil
+}
+
+func startPolicyServer(t *testing.T, server *Server, options map[string]interface{}) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	options["SERVER"] = map[string]interface{}{
+		"ADDRESS": tADDRESS,
+		"PORT":    tPORT,
+	}
+	options["PASSWORD"] = map[string]interface{}{
+		"ENABLED": true,
+		"FILE":    writeHtpasswd(t),
+	}
+	conf, err := config.WithInitialValues(ctx, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Listen(ctx, conf, keystore); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ctx)
+	t.Cleanup(func() {
+		server.Stop(ctx)
+	})
+	return server.GetAddr().String()
+}
+
+func knownUserKey(t *testing.T, user string) ssh.Signer {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keystore.AddKnownHost(context.Background(), user, publicKey); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func dialWith(addr, user string, methods ...ssh.AuthMethod) error {
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		client.Close()
+	}
+	return err
+}
+
+func TestAuthPolicyFromConfig(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keystore.(TOTPSecretStore).SetTOTPSecret(context.Background(), "bob", secret); err != nil {
+		t.Fatal(err)
+	}
+	addr := startPolicyServer(t, &Server{}, map[string]interface{}{
+		"TOTP": map[string]interface{}{
+			"ENABLED": true,
+		},
+		"AUTH": map[string]interface{}{
+			"METHODS": "publickey",
+			"POLICIES": map[string]interface{}{
+				"0": map[string]interface{}{
+					"USERS":   "bob",
+					"METHODS": "password,keyboard-interactive",
+					"ORDERED": true,
+				},
+			},
+		},
+	})
+	otp := ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		return []string{NewTOTP(6, 30*time.Second, 1).Code(secret, time.Now())}, nil
+	})
+
+	assert.Error(t, dialWith(addr, "bob", ssh.Password("argon-secret")))
+	// keyboard-interactive is refused before the password and the client
+	// does not retry it
+	assert.Error(t, dialWith(addr, "bob", otp, ssh.Password("argon-secret")))
+	assert.NoError(t, dialWith(addr, "bob", ssh.Password("argon-secret"), otp))
+
+	// the default policy only allows public keys
+	assert.NoError(t, dialWith(addr, "service", ssh.PublicKeys(knownUserKey(t, "service"))))
+	assert.Error(t, dialWith(addr, "alice", ssh.Password("bcrypt-secret")))
+}
+
+func TestAuthPolicyProvider(t *testing.T) {
+	addr := startPolicyServer(t, &Server{
+		AuthPolicies: staticPolicies{
+			"alice": {Methods: []string{MethodPassword, MethodPublicKey}},
+		},
+	}, map[string]interface{}{})
+	signer := knownUserKey(t, "alice")
+
+	assert.Error(t, dialWith(addr, "alice", ssh.PublicKeys(signer)))
+	assert.NoError(t, dialWith(addr, "alice", ssh.PublicKeys(signer), ssh.Password("bcrypt-secret")))
+	// without a policy any single method is enough
+	assert.NoError(t, dialWith(addr, "bob", ssh.Password("argon-secret")))
+}
+
+func TestLoadAuthPoliciesInvalid(t *testing.T) {
+	conf, err := config.WithInitialValues(context.Background(), map[string]interface{}{
+		"AUTH": map[string]interface{}{
+			"METHODS": "publickey,hostbased",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadAuthPolicies(context.Background(), conf)
+	assert.ErrorIs(t, err, ErrSSHConfig)
+	assert.ErrorContains(t, err, "hostbased")
+}
+
+func TestLoadAuthPolicies(t *testing.T) {
+	ctx := context.Background()
+	conf, err := config.WithInitialValues(ctx, defaultServerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := loadAuthPolicies(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, policies.users)
+
+	conf, err = config.WithInitialValues(ctx, map[string]interface{}{
+		"AUTH": map[string]interface{}{
+			"POLICIES": map[string]interface{}{
+				"0": map[string]interface{}{
+					"USERS":   "alice, bob",
+					"METHODS": "publickey,password",
+				},
+				"1": map[string]interface{}{
+					"USERS":   "carol",
+					"METHODS": "password,keyboard-interactive",
+					"ORDERED": true,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err = loadAuthPolicies(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, policies.users, 3)
+	assert.Equal(t, AuthPolicy{Methods: []string{MethodPublicKey, MethodPassword}}, policies.users["bob"])
+	assert.Equal(t, AuthPolicy{Methods: []string{MethodPassword, MethodKeyboardInteractive}, Ordered: true}, policies.users["carol"])
+}
@@ -0,0 +1,234 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrNoUserCA indicates that the keystore has no key to sign user
+	// certificates.
+	ErrNoUserCA = errors.New("no user ca key set")
+)
+
+// The critical options of user certificates the server enforces, see
+// PROTOCOL.certkeys of OpenSSH.
+const (
+	CertForceCommand  = "force-command"
+	CertSourceAddress = "source-address"
+)
+
+// DefaultUserExtensions are the extensions OpenSSH grants certificates by
+// default.
+var DefaultUserExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// UserCertOptions describe a user certificate to issue.
+type UserCertOptions struct {
+	KeyID string
+	// Principals are the user names the certificate is valid for.
+	Principals []string
+	// ValidAfter and ValidBefore bound the validity, a zero time leaves
+	// that side open.
+	ValidAfter  time.Time
+	ValidBefore time.Time
+	// CriticalOptions restrict the certificate, e.g. CertForceCommand.
+	CriticalOptions map[string]string
+	// Extensions grant features, DefaultUserExtensions if nil.
+	Extensions map[string]string
+}
+
+func (ks *sshKeystore) SetUserCAKey(ctx context.Context, pemBytes []byte) error {
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return err
+	}
+	ks.Lock()
+	ks.userCA = signer
+	ks.Unlock()
+	return nil
+}
+
+func (ks *sshKeystore) SignUserKey(ctx context.Context, key ssh.PublicKey, options UserCertOptions) (*ssh.Certificate, error) {
+	ks.RLock()
+	ca := ks.userCA
+	ks.RUnlock()
+	if ca == nil {
+		return nil, ErrNoUserCA
+	}
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+	extensions := options.Extensions
+	if extensions == nil {
+		extensions = DefaultUserExtensions
+	}
+	validAfter, validBefore := uint64(0), uint64(ssh.CertTimeInfinity)
+	if !options.ValidAfter.IsZero() {
+		validAfter = uint64(options.ValidAfter.Unix())
+	}
+	if !options.ValidBefore.IsZero() {
+		validBefore = uint64(options.ValidBefore.Unix())
+	}
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           options.KeyID,
+		ValidPrincipals: options.Principals,
+		ValidAfter:      validAfter,
+		ValidBefore:     validBefore,
+		Permissions: ssh.Permissions{
+			CriticalOptions: options.CriticalOptions,
+			Extensions:      extensions,
+		},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, fmt.Errorf("could not sign certificate: %w", err)
+	}
+	return cert, nil
+}
+
+func (ks *sshKeystore) GenerateUserKey(ctx context.Context, username string, validity time.Duration) (crypto.PrivateKey, *ssh.Certificate, error) {
+	private, err := GenerateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	public, err := ssh.NewPublicKey(private.(ed25519.PrivateKey).Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	cert, err := ks.SignUserKey(ctx, public, UserCertOptions{
+		KeyID:      username,
+		Principals: []string{username},
+		// tolerates clients with a slightly late clock
+		ValidAfter:  now.Add(-time.Minute),
+		ValidBefore: now.Add(validity),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return private, cert, nil
+}
+
+// verifyUserCertificate checks a user certificate against the trusted
+// CAs, including its principals, validity and critical options.
+func (s *Server) verifyUserCertificate(conn ssh.ConnMetadata, cert *ssh.Certificate) (*ssh.Permissions, error) {
+	// ssh.CertChecker accepts certificates without principals for any
+	// user, OpenSSH rejects them
+	if len(cert.ValidPrincipals) == 0 {
+		return nil, ErrAuthFailedReason{errors.New("certificate has no principals")}
+	}
+	auth := s.authSettings()
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(authority ssh.PublicKey) bool {
+			for _, ca := range auth.userCAs {
+				if bytes.Equal(ca.Marshal(), authority.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		SupportedCriticalOptions: []string{CertForceCommand, CertSourceAddress},
+	}
+	permissions, err := checker.Authenticate(conn, cert)
+	if err != nil {
+		return nil, ErrAuthFailedReason{err}
+	}
+	// x/crypto only checks source-address for permissions returned to it,
+	// not for the steps of a multi-factor policy
+	if allowed, ok := permissions.CriticalOptions[CertSourceAddress]; ok {
+		if err := checkSourceAddress(conn.RemoteAddr(), allowed); err != nil {
+			return nil, ErrAuthFailedReason{err}
+		}
+	}
+	if permissions.CriticalOptions == nil {
+		permissions.CriticalOptions = make(map[string]string)
+	}
+	permissions.CriticalOptions["pubkey-fp"] = ssh.FingerprintSHA256(cert.Key)
+	permissions.CriticalOptions[certAuthorityOption] = ssh.FingerprintSHA256(cert.SignatureKey)
+	return permissions, nil
+}
+
+// checkSourceAddress returns an error unless addr matches one of the comma
+// separated addresses and CIDR ranges of a source-address option.
+func checkSourceAddress(addr net.Addr, allowed string) error {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return fmt.Errorf("could not parse remote address %s: %w", addr.String(), err)
+	}
+	ip := addrPort.Addr().Unmap()
+	for _, entry := range strings.Split(allowed, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			if allowedIP, err := netip.ParseAddr(entry); err != nil {
+				return fmt.Errorf("invalid source-address %s: %w", entry, err)
+			} else if allowedIP.Unmap() == ip {
+				return nil
+			}
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return fmt.Errorf("invalid source-address %s: %w", entry, err)
+		}
+		if prefix.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("source address %s is not allowed", ip)
+}
+
+// certAuthorityOption marks permissions granted by a certificate with the
+// fingerprint of its CA.
+const certAuthorityOption = "cert-authority-fp"
+
+// loadUserCAs reads the trusted CA keys of USERCA, given in authorized_keys
+// format in KEYS and FILE, in addition to the TrustedUserCAs of the server.
+func (s *Server) loadUserCAs(ctx context.Context, conf *config.Config) ([]ssh.PublicKey, error) {
+	cas := append([]ssh.PublicKey(nil), s.TrustedUserCAs...)
+	caConfig, err := conf.GetConfig(ctx, "USERCA")
+	if err != nil || caConfig == nil {
+		return cas, nil
+	}
+	keys, _ := caConfig.Get(ctx, "KEYS")
+	if path, _ := caConfig.Get(ctx, "FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, ErrSSHConfigReason{fmt.Errorf("could not read user ca file: %w", err)}
+		}
+		keys += "\n" + string(content)
+	}
+	for _, line := range strings.Split(keys, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, ErrSSHConfigReason{fmt.Errorf("could not parse user ca key: %w", err)}
+		}
+		cas = append(cas, key)
+	}
+	return cas, nil
+}
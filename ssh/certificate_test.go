@@ -0,0 +1,242 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// newCAKeystore returns a keystore with a user CA key and the CA public
+// key in authorized_keys format.
+func newCAKeystore(t *testing.T) (*sshKeystore, string) {
+	t.Helper()
+	ctx := context.Background()
+	pemKey := func() []byte {
+		private, err := GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := ssh.MarshalPrivateKey(private, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(block)
+	}
+	store, err := NewKeystore(pemKey(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := store.(*sshKeystore)
+	caKey := pemKey()
+	if err := ks.SetUserCAKey(ctx, caKey); err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.ParsePrivateKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks, string(ssh.MarshalAuthorizedKey(caSigner.PublicKey()))
+}
+
+func startCAServer(t *testing.T, ks Keystore, caKeys string, handlers func(*Server)) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"SERVER": map[string]interface{}{
+			"ADDRESS": tADDRESS,
+			"PORT":    tPORT,
+		},
+		"USERCA": map[string]interface{}{
+			"KEYS": caKeys,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf, ks); err != nil {
+		t.Fatal(err)
+	}
+	if handlers != nil {
+		handlers(server)
+	}
+	go server.Serve(ctx)
+	t.Cleanup(func() {
+		server.Stop(ctx)
+	})
+	return server.GetAddr().String()
+}
+
+func certSigner(t *testing.T, private interface{}, cert *ssh.Certificate) ssh.AuthMethod {
+	t.Helper()
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ssh.PublicKeys(certSigner)
+}
+
+func TestUserCertificates(t *testing.T) {
+	ctx := context.Background()
+	ks, caKeys := newCAKeystore(t)
+	sessions := make(chan *SessionInfo, 1)
+	addr := startCAServer(t, ks, caKeys, func(server *Server) {
+		server.ChannelHandlers["pepper-test"] = func(ctx context.Context, channel ssh.NewChannel) error {
+			session, _ := SessionFromContext(ctx)
+			sessions <- session
+			return channel.Reject(ssh.Prohibited, "test channel")
+		}
+	})
+
+	private, cert, err := ks.GenerateUserKey(ctx, "carol", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"carol"}, cert.ValidPrincipals)
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "carol",
+		Auth:            []ssh.AuthMethod{certSigner(t, private, cert)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.OpenChannel("pepper-test", nil)
+	client.Close()
+	session := <-sessions
+	assert.NotEmpty(t, session.CertAuthority)
+	assert.True(t, session.Permits("permit-pty"))
+
+	// other principals, expired certificates and unknown CAs are refused
+	assert.Error(t, dialWith(addr, "dave", certSigner(t, private, cert)))
+	expired, err := ks.SignUserKey(ctx, cert.Key, UserCertOptions{
+		Principals:  []string{"carol"},
+		ValidAfter:  time.Now().Add(-time.Hour),
+		ValidBefore: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, dialWith(addr, "carol", certSigner(t, private, expired)))
+	other, _ := newCAKeystore(t)
+	untrusted, err := other.SignUserKey(ctx, cert.Key, UserCertOptions{
+		Principals:  []string{"carol"},
+		ValidBefore: time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, dialWith(addr, "carol", certSigner(t, private, untrusted)))
+}
+
+func TestUserCertificateRestrictions(t *testing.T) {
+	ctx := context.Background()
+	ks, caKeys := newCAKeystore(t)
+	commands := make(chan string, 1)
+	addr := startCAServer(t, ks, caKeys, func(server *Server) {
+		server.RequestHandlers["exec"] = func(ctx context.Context, channel ssh.Channel, req *ssh.Request) error {
+			var payload execRequest
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				return err
+			}
+			commands <- payload.Command
+			return req.Reply(true, nil)
+		}
+	})
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(options map[string]string) *ssh.Certificate {
+		cert, err := ks.SignUserKey(ctx, key, UserCertOptions{
+			Principals:      []string{"erin"},
+			ValidBefore:     time.Now().Add(time.Minute),
+			CriticalOptions: options,
+			Extensions:      map[string]string{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	assert.Error(t, dialWith(addr, "erin", certSigner(t, private, sign(map[string]string{CertSourceAddress: "10.0.0.0/8"}))))
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "erin",
+		Auth:            []ssh.AuthMethod{certSigner(t, private, sign(map[string]string{CertForceCommand: "uptime"}))},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	assert.Error(t, session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}))
+	if err := session.Start("rm -rf /"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "uptime", <-commands)
+	_, err = client.Dial("tcp", "127.0.0.1:1")
+	assert.Error(t, err)
+}
+
+func TestUserCertificateMultiStep(t *testing.T) {
+	ctx := context.Background()
+	ks, caKeys := newCAKeystore(t)
+	addr := startPolicyServer(t, &Server{keystore: ks}, map[string]interface{}{
+		"USERCA": map[string]interface{}{
+			"KEYS": caKeys,
+		},
+		"AUTH": map[string]interface{}{
+			"METHODS": "publickey,password",
+			"ORDERED": true,
+		},
+	})
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(principals []string, options map[string]string) ssh.AuthMethod {
+		cert, err := ks.SignUserKey(ctx, key, UserCertOptions{
+			Principals:      principals,
+			ValidBefore:     time.Now().Add(time.Minute),
+			CriticalOptions: options,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return certSigner(t, private, cert)
+	}
+	password := ssh.Password("bcrypt-secret")
+
+	assert.NoError(t, dialWith(addr, "alice", sign([]string{"alice"}, nil), password))
+	assert.NoError(t, dialWith(addr, "alice", sign([]string{"alice"}, map[string]string{CertSourceAddress: "10.0.0.1,127.0.0.0/8"}), password))
+	assert.Error(t, dialWith(addr, "alice", sign([]string{"alice"}, map[string]string{CertSourceAddress: "10.0.0.0/8"}), password))
+	// a certificate without principals is valid for nobody
+	assert.Error(t, dialWith(addr, "alice", sign(nil, nil), password))
+}
@@ -2,8 +2,10 @@ package ssh
 
 import (
 	"context"
+	"strconv"
 	"sync"
 
+	"github.com/myLogic207/pepper/base"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -11,11 +13,66 @@ type contextKey string
 
 const contextKeyChannelID = contextKey("channel-id")
 
-// Work blocks until the connection is served.
+// restrictedChannels and restrictedRequests require an extension if the
+// user authenticated with a certificate.
+var (
+	restrictedChannels = map[string]string{
+		"direct-tcpip":                   "permit-port-forwarding",
+		"direct-streamlocal@openssh.com": "permit-port-forwarding",
+	}
+	restrictedRequests = map[string]string{
+		"pty-req":                    "permit-pty",
+		"x11-req":                    "permit-X11-forwarding",
+		"auth-agent-req@openssh.com": "permit-agent-forwarding",
+	}
+)
+
+// Work blocks until the connection is served. Once ctx is cancelled, new
+// channels are rejected and the connection is closed as soon as all open
+// channels are done.
 func (s *Server) WorkConnect(ctx context.Context, sshConn ssh.Conn, chans <-chan ssh.NewChannel) error {
 	chanCounter := 0
+	// openChannels is shown in the connection registry of the base server
+	openChannels := 0
+	openChannelsLock := sync.Mutex{}
+	countChannels := func(delta int) {
+		openChannelsLock.Lock()
+		openChannels += delta
+		base.Annotate(ctx, "channels", strconv.Itoa(openChannels))
+		openChannelsLock.Unlock()
+	}
+	countChannels(0)
 	waitGroup := sync.WaitGroup{}
+	// drainLock orders the cancellation check against waitGroup.Add, so the
+	// drain goroutine never waits while new channels are being added.
+	drainLock := sync.Mutex{}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-finished:
+			return
+		}
+		drainLock.Lock()
+		drainLock.Unlock()
+		waitGroup.Wait()
+		sshConn.Close()
+	}()
+
 	for newChannel := range chans {
+		s.metrics.channels.With(s.metrics.name, newChannel.ChannelType()).Inc()
+		drainLock.Lock()
+		if ctx.Err() != nil {
+			drainLock.Unlock()
+			newChannel.Reject(ssh.ResourceShortage, "server is shutting down")
+			continue
+		}
+		if extension, ok := restrictedChannels[newChannel.ChannelType()]; ok && !permits(ctx, extension) {
+			drainLock.Unlock()
+			newChannel.Reject(ssh.Prohibited, "not permitted by certificate")
+			continue
+		}
 		chanCtx := context.WithValue(ctx, contextKeyChannelID, chanCounter)
 		handler, ok := s.ChannelHandlers[newChannel.ChannelType()]
 		if !ok {
@@ -23,10 +80,13 @@ func (s *Server) WorkConnect(ctx context.Context, sshConn ssh.Conn, chans <-chan
 		}
 		chanCounter++
 		waitGroup.Add(1)
+		drainLock.Unlock()
+		countChannels(1)
 		go func(channel ssh.NewChannel) {
 			if err := handler(chanCtx, channel); err != nil {
 				s.logger.Error(chanCtx, "Error handling channel %s", err)
 			}
+			countChannels(-1)
 			waitGroup.Done()
 		}(newChannel)
 	}
@@ -40,7 +100,17 @@ func (s *Server) DefaultChannelHandler(ctx context.Context, channel ssh.NewChann
 		return err
 	}
 	waitGroup := sync.WaitGroup{}
+	session, _ := SessionFromContext(ctx)
 	for req := range requests {
+		s.metrics.requests.With(s.metrics.name, req.Type).Inc()
+		if extension, ok := restrictedRequests[req.Type]; ok && !permits(ctx, extension) {
+			req.Reply(false, nil)
+			continue
+		}
+		if session != nil && session.ForceCommand != "" && (req.Type == "exec" || req.Type == "shell" || req.Type == "subsystem") {
+			req.Type = "exec"
+			req.Payload = ssh.Marshal(execRequest{Command: session.ForceCommand})
+		}
 		requestHandler, ok := s.RequestHandlers[req.Type]
 		if !ok {
 			requestHandler = s.DefaultRequestHandler
@@ -57,6 +127,18 @@ func (s *Server) DefaultChannelHandler(ctx context.Context, channel ssh.NewChann
 	return nil
 }
 
+// execRequest is the payload of an exec request.
+type execRequest struct {
+	Command string
+}
+
+// permits reports whether the session of ctx is granted extension, see
+// SessionInfo.Permits.
+func permits(ctx context.Context, extension string) bool {
+	session, ok := SessionFromContext(ctx)
+	return !ok || session.Permits(extension)
+}
+
 func (s *Server) DefaultRequestHandler(ctx context.Context, channel ssh.Channel, req *ssh.Request) error {
 	s.logger.Debug(ctx, "Request %s", req.Type)
 	s.logger.Debug(ctx, "Request Payload %s", req.Payload)
@@ -0,0 +1,449 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/myLogic207/gotils/config"
+	"github.com/myLogic207/pepper/dbconnect"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrLockedOut indicates that a user is locked out after too many failed
+	// password or TOTP attempts.
+	ErrLockedOut = errors.New("user is locked out")
+
+	// ErrUnsupportedHash indicates a password hash of an unknown format.
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+)
+
+// CredentialStore verifies the passwords of users.
+type CredentialStore interface {
+	// Verify checks the password of user. It returns ErrAuthFailed for
+	// unknown users and wrong passwords and ErrLockedOut while the user is
+	// locked out.
+	Verify(ctx context.Context, user string, password []byte) error
+	// LockedUntil returns the end of the lockout of user, the zero time if
+	// the user is not locked out.
+	LockedUntil(ctx context.Context, user string) time.Time
+}
+
+// LockoutPolicy locks users out for Duration after MaxFailures failed
+// attempts in a row. A MaxFailures below 1 disables the lockout.
+type LockoutPolicy struct {
+	MaxFailures int
+	Duration    time.Duration
+}
+
+type failures struct {
+	count int
+	until time.Time
+}
+
+// lockout tracks the failed attempts of users for a LockoutPolicy. Only
+// known users are tracked, so unknown names can not grow it.
+type lockout struct {
+	sync.Mutex
+	policy LockoutPolicy
+	users  map[string]*failures
+	// params are the hash parameters of the last stored hash, unknown users
+	// are compared against the dummy hash of dummies with these parameters
+	params  string
+	dummies map[string]string
+}
+
+func newLockout(policy LockoutPolicy) *lockout {
+	return &lockout{policy: policy, users: make(map[string]*failures), dummies: make(map[string]string)}
+}
+
+// follow makes unknown users fail with the scheme and parameters of hash.
+func (l *lockout) follow(hash string) error {
+	params := hashParams(hash)
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.dummies[params]; !ok {
+		dummy, err := dummyHash(hash)
+		if err != nil {
+			return err
+		}
+		l.dummies[params] = dummy
+	}
+	l.params = params
+	return nil
+}
+
+// unknownHash returns the dummy hash compared for unknown users.
+func (l *lockout) unknownHash() (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	if dummy, ok := l.dummies[l.params]; ok {
+		return dummy, nil
+	}
+	dummy, err := dummyHash("")
+	if err != nil {
+		return "", err
+	}
+	l.params = hashParams(dummy)
+	l.dummies[l.params] = dummy
+	return dummy, nil
+}
+
+func (l *lockout) lockedUntil(user string, now time.Time) time.Time {
+	l.Lock()
+	defer l.Unlock()
+	if state, ok := l.users[user]; ok && now.Before(state.until) {
+		return state.until
+	}
+	return time.Time{}
+}
+
+// record updates the failed attempts of user after a verification, user
+// has to be known.
+func (l *lockout) record(user string, ok bool, now time.Time) {
+	if l.policy.MaxFailures < 1 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if ok {
+		delete(l.users, user)
+		return
+	}
+	state, found := l.users[user]
+	if !found || (!state.until.IsZero() && !now.Before(state.until)) {
+		state = &failures{}
+		l.users[user] = state
+	}
+	state.count++
+	if state.count >= l.policy.MaxFailures {
+		state.until = now.Add(l.policy.Duration)
+	}
+}
+
+// verify checks password against hash, an empty hash stands for an unknown
+// user. Every path compares a hash, so unknown users and locked out users
+// take as long as wrong passwords.
+func (l *lockout) verify(user, hash string, password []byte) error {
+	now := time.Now()
+	locked := !l.lockedUntil(user, now).IsZero()
+	known := hash != ""
+	if !known {
+		dummy, err := l.unknownHash()
+		if err != nil {
+			return err
+		}
+		hash = dummy
+	}
+	ok, err := verifyHash(hash, password)
+	if err != nil {
+		return err
+	}
+	if !known {
+		return ErrAuthFailed
+	}
+	if err := l.follow(hash); err != nil {
+		return err
+	}
+	if locked {
+		return ErrLockedOut
+	}
+	l.record(user, ok, now)
+	if !ok {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// dummyHash returns the hash of a random secret with the scheme and the
+// parameters of like, so verifying it takes as long. It falls back to the
+// argon2id parameters of HashPassword if like is empty or not understood.
+func dummyHash(like string) (string, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	parts := strings.Split(like, "$")
+	switch {
+	case strings.HasPrefix(like, "$2a$"), strings.HasPrefix(like, "$2b$"), strings.HasPrefix(like, "$2y$"):
+		if cost, err := bcrypt.Cost([]byte(like)); err == nil {
+			if hash, err := bcrypt.GenerateFromPassword(secret, cost); err == nil {
+				return string(hash), nil
+			}
+		}
+	case strings.HasPrefix(like, "$argon2id$") && len(parts) == 6:
+		salt, saltErr := base64.RawStdEncoding.DecodeString(parts[4])
+		key, keyErr := base64.RawStdEncoding.DecodeString(parts[5])
+		if saltErr == nil && keyErr == nil {
+			// a random key matches no password, deriving one is not needed
+			if _, err := rand.Read(salt); err != nil {
+				return "", err
+			}
+			if _, err := rand.Read(key); err != nil {
+				return "", err
+			}
+			return strings.Join(append(parts[:4], base64.RawStdEncoding.EncodeToString(salt),
+				base64.RawStdEncoding.EncodeToString(key)), "$"), nil
+		}
+	}
+	return HashPassword(secret)
+}
+
+// hashParams returns the scheme and cost parameters of hash, hashes with
+// equal parameters take as long to verify.
+func hashParams(hash string) string {
+	parts := strings.Split(hash, "$")
+	if len(parts) == 6 && parts[1] == "argon2id" {
+		return strings.Join(parts[:4], "$") + "$" + strconv.Itoa(len(parts[5]))
+	} else if len(parts) >= 3 {
+		return strings.Join(parts[:3], "$")
+	}
+	return hash
+}
+
+// argon2id parameters of HashPassword
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+
+	// maxArgonMemory bounds the memory in KiB a stored hash may demand
+	maxArgonMemory = 1024 * 1024
+)
+
+// HashPassword returns the argon2id hash of password in the PHC string
+// format understood by the credential stores.
+func HashPassword(password []byte) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(password, salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyHash compares password with a bcrypt ($2a$, $2b$, $2y$) or
+// argon2id hash.
+func verifyHash(hash string, password []byte) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), password)
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("%w: %s", ErrUnsupportedHash, err.Error())
+		}
+		return true, nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2id(hash, password)
+	}
+	return false, ErrUnsupportedHash
+}
+
+func verifyArgon2id(hash string, password []byte) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, ErrUnsupportedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrUnsupportedHash
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, ErrUnsupportedHash
+	}
+	// argon2 needs 8 KiB per thread, x/crypto panics on zero threads
+	if threads == 0 || iterations == 0 || memory < 8*uint32(threads) || memory > maxArgonMemory {
+		return false, fmt.Errorf("%w: invalid argon2id parameters %s", ErrUnsupportedHash, parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrUnsupportedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, ErrUnsupportedHash
+	}
+	derived := argon2.IDKey(password, salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(derived, key) == 1, nil
+}
+
+// HtpasswdStore reads "user:hash" lines from a file, blank lines and lines
+// starting with # are skipped.
+type HtpasswdStore struct {
+	lockout *lockout
+	mu      sync.RWMutex
+	path    string
+	hashes  map[string]string
+}
+
+// NewHtpasswdStore loads the credentials in path.
+func NewHtpasswdStore(path string, policy LockoutPolicy) (*HtpasswdStore, error) {
+	store := &HtpasswdStore{lockout: newLockout(policy), path: path}
+	if err := store.Load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Load reads the file again, e.g. after users were added.
+func (hs *HtpasswdStore) Load() error {
+	file, err := os.Open(hs.path)
+	if err != nil {
+		return fmt.Errorf("could not open password file: %w", err)
+	}
+	defer file.Close()
+	hashes := make(map[string]string)
+	var first string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(entry, ":")
+		if !found || user == "" || hash == "" {
+			return fmt.Errorf("invalid entry in password file on line %d", line)
+		}
+		hashes[user] = hash
+		if first == "" {
+			first = hash
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read password file: %w", err)
+	}
+	hs.mu.Lock()
+	hs.hashes = hashes
+	hs.mu.Unlock()
+	if first != "" {
+		return hs.lockout.follow(first)
+	}
+	return nil
+}
+
+func (hs *HtpasswdStore) Verify(ctx context.Context, user string, password []byte) error {
+	hs.mu.RLock()
+	hash := hs.hashes[user]
+	hs.mu.RUnlock()
+	return hs.lockout.verify(user, hash, password)
+}
+
+func (hs *HtpasswdStore) LockedUntil(ctx context.Context, user string) time.Time {
+	return hs.lockout.lockedUntil(user, time.Now())
+}
+
+// SQLStore reads password hashes from a table with a user and a hash
+// column.
+type SQLStore struct {
+	lockout    *lockout
+	db         *dbconnect.DB
+	builder    squirrel.StatementBuilderType
+	table      string
+	userColumn string
+	hashColumn string
+}
+
+// NewSQLStore creates a store reading hashColumn of the row of table whose
+// userColumn matches the user.
+func NewSQLStore(ctx context.Context, db *dbconnect.DB, table, userColumn, hashColumn string, policy LockoutPolicy) *SQLStore {
+	return &SQLStore{
+		lockout:    newLockout(policy),
+		db:         db,
+		builder:    dbconnect.NewBuilder(ctx, db.Type(ctx)),
+		table:      table,
+		userColumn: userColumn,
+		hashColumn: hashColumn,
+	}
+}
+
+func (ss *SQLStore) Verify(ctx context.Context, user string, password []byte) error {
+	var hash string
+	err := ss.builder.
+		Select(ss.hashColumn).
+		From(ss.table).
+		Where(squirrel.Eq{ss.userColumn: user}).
+		RunWith(ss.db.DB).QueryRowContext(ctx).Scan(&hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("could not query password hash: %w", err)
+	}
+	return ss.lockout.verify(user, hash, password)
+}
+
+func (ss *SQLStore) LockedUntil(ctx context.Context, user string) time.Time {
+	return ss.lockout.lockedUntil(user, time.Now())
+}
+
+// lockoutOf returns the lockout of the stores of this package, nil for
+// other stores.
+func lockoutOf(store CredentialStore) *lockout {
+	switch store := store.(type) {
+	case *HtpasswdStore:
+		return store.lockout
+	case *SQLStore:
+		return store.lockout
+	}
+	return nil
+}
+
+// loadCredentials creates the store checking passwords as configured in
+// the PASSWORD section, nil if password authentication is disabled. The
+// Credentials of the server take precedence over FILE. current is reused
+// if it reads the same file with the same lockout, so failed attempts are
+// kept across reloads.
+func (s *Server) loadCredentials(ctx context.Context, conf *config.Config, current CredentialStore) (CredentialStore, error) {
+	passwordConfig, err := conf.GetConfig(ctx, "PASSWORD")
+	if err != nil || passwordConfig == nil {
+		return nil, nil
+	}
+	if enabled, _ := passwordConfig.Get(ctx, "ENABLED"); enabled != "true" {
+		return nil, nil
+	}
+	if s.Credentials != nil {
+		return s.Credentials, nil
+	}
+	path, _ := passwordConfig.Get(ctx, "FILE")
+	if path == "" {
+		return nil, ErrSSHConfigReason{errors.New("password auth enabled without credentials or PASSWORD.FILE")}
+	}
+	maxFailuresRaw, _ := passwordConfig.Get(ctx, "MAXFAILURES")
+	maxFailures, err := strconv.Atoi(maxFailuresRaw)
+	if err != nil {
+		return nil, ErrSSHConfigReason{fmt.Errorf("could not parse max failures: %w", err)}
+	}
+	durationRaw, _ := passwordConfig.Get(ctx, "LOCKOUT")
+	duration, err := time.ParseDuration(durationRaw)
+	if err != nil {
+		return nil, ErrSSHConfigReason{fmt.Errorf("could not parse lockout: %w", err)}
+	}
+	policy := LockoutPolicy{MaxFailures: maxFailures, Duration: duration}
+
+	if store, ok := current.(*HtpasswdStore); ok && store.path == path && store.lockout.policy == policy {
+		if err := store.Load(); err != nil {
+			return nil, ErrSSHConfigReason{err}
+		}
+		return store, nil
+	}
+	store, err := NewHtpasswdStore(path, policy)
+	if err != nil {
+		return nil, ErrSSHConfigReason{err}
+	}
+	return store, nil
+}
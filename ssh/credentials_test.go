@@ -0,0 +1,177 @@
+package ssh
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/myLogic207/pepper/dbconnect"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+func writeHtpasswd(t *testing.T) string {
+	t.Helper()
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argonHash, err := HashPassword([]byte("argon-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# test users\nalice:" + string(bcryptHash) + "\n\nbob:" + argonHash + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHtpasswdStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewHtpasswdStore(writeHtpasswd(t), LockoutPolicy{MaxFailures: 2, Duration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, store.Verify(ctx, "alice", []byte("bcrypt-secret")))
+	assert.NoError(t, store.Verify(ctx, "bob", []byte("argon-secret")))
+	assert.ErrorIs(t, store.Verify(ctx, "bob", []byte("bcrypt-secret")), ErrAuthFailed)
+	assert.ErrorIs(t, store.Verify(ctx, "mallory", []byte("bcrypt-secret")), ErrAuthFailed)
+
+	// a success resets the failures
+	assert.NoError(t, store.Verify(ctx, "bob", []byte("argon-secret")))
+	assert.ErrorIs(t, store.Verify(ctx, "alice", []byte("wrong")), ErrAuthFailed)
+	assert.True(t, store.LockedUntil(ctx, "alice").IsZero())
+	assert.ErrorIs(t, store.Verify(ctx, "alice", []byte("wrong")), ErrAuthFailed)
+	assert.False(t, store.LockedUntil(ctx, "alice").IsZero())
+	assert.ErrorIs(t, store.Verify(ctx, "alice", []byte("bcrypt-secret")), ErrLockedOut)
+	assert.NoError(t, store.Verify(ctx, "bob", []byte("argon-secret")))
+
+	// unknown users are not tracked
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, store.Verify(ctx, "mallory", []byte("wrong")), ErrAuthFailed)
+	}
+	assert.True(t, store.LockedUntil(ctx, "mallory").IsZero())
+	assert.Len(t, store.lockout.users, 1)
+}
+
+func TestDummyHash(t *testing.T) {
+	argonHash, err := HashPassword([]byte("argon-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, like := range []string{"", argonHash, string(bcryptHash)} {
+		dummy, err := dummyHash(like)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if like != "" {
+			assert.Equal(t, hashParams(like), hashParams(dummy))
+		}
+		ok, err := verifyHash(dummy, []byte("argon-secret"))
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+	// unknown users fail like the users of the file before anyone logged in
+	unknown, err := newLockout(LockoutPolicy{}).unknownHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, hashParams(argonHash), hashParams(unknown))
+	store, err := NewHtpasswdStore(writeHtpasswd(t), LockoutPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unknown, err = store.lockout.unknownHash(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, hashParams(string(bcryptHash)), hashParams(unknown))
+}
+
+func TestVerifyHashInvalid(t *testing.T) {
+	_, err := verifyHash("plaintext", []byte("plaintext"))
+	assert.ErrorIs(t, err, ErrUnsupportedHash)
+	_, err = verifyHash("$argon2id$v=19$m=1,t=1$salt$key", []byte("secret"))
+	assert.ErrorIs(t, err, ErrUnsupportedHash)
+	for _, params := range []string{"m=65536,t=3,p=0", "m=65536,t=0,p=4", "m=16,t=3,p=4", "m=4294967295,t=3,p=4"} {
+		_, err = verifyHash("$argon2id$v=19$"+params+"$c2FsdHNhbHQ$a2V5a2V5", []byte("secret"))
+		assert.ErrorIs(t, err, ErrUnsupportedHash, params)
+	}
+}
+
+func TestSQLStore(t *testing.T) {
+	ctx := context.Background()
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxOpenConns(1)
+	db, err := dbconnect.NewWithConnector(ctx, conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hash, err := HashPassword([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("CREATE TABLE users (name TEXT PRIMARY KEY, hash TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("INSERT INTO users (name, hash) VALUES (?, ?)", "alice", hash); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewSQLStore(ctx, db, "users", "name", "hash", LockoutPolicy{})
+	assert.NoError(t, store.Verify(ctx, "alice", []byte("secret")))
+	assert.ErrorIs(t, store.Verify(ctx, "alice", []byte("wrong")), ErrAuthFailed)
+	assert.ErrorIs(t, store.Verify(ctx, "bob", []byte("secret")), ErrAuthFailed)
+}
+
+func TestPasswordAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"SERVER": map[string]interface{}{
+			"ADDRESS": tADDRESS,
+			"PORT":    tPORT,
+		},
+		"PASSWORD": map[string]interface{}{
+			"ENABLED": true,
+			"FILE":    writeHtpasswd(t),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	if err := server.Listen(ctx, conf, keystore); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ctx)
+	defer server.Stop(ctx)
+
+	dial := func(password string) error {
+		client, err := ssh.Dial("tcp", server.GetAddr().String(), &ssh.ClientConfig{
+			User:            "bob",
+			Auth:            []ssh.AuthMethod{ssh.Password(password)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	assert.NoError(t, dial("argon-secret"))
+	assert.Error(t, dial("wrong"))
+}
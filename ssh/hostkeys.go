@@ -0,0 +1,403 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrUnknownHostKey indicates a host key or certificate for a key that
+	// is not in the keystore.
+	ErrUnknownHostKey = errors.New("unknown host key")
+
+	// ErrNotHostCertificate indicates a certificate of another type.
+	ErrNotHostCertificate = errors.New("not a host certificate")
+)
+
+// The global requests of the OpenSSH host key update protocol, see
+// PROTOCOL of OpenSSH, section 2.5.
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+func (ks *sshKeystore) AddHostKey(ctx context.Context, pemBytes []byte) error {
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return err
+	}
+	ks.Lock()
+	defer ks.Unlock()
+	if ks.hostKeyIndex(signer.PublicKey()) < 0 {
+		ks.hostKeys = append(ks.hostKeys, signer)
+	}
+	return nil
+}
+
+func (ks *sshKeystore) RemoveHostKey(ctx context.Context, key ssh.PublicKey) error {
+	ks.Lock()
+	defer ks.Unlock()
+	i := ks.hostKeyIndex(key)
+	if i < 0 {
+		return ErrUnknownHostKey
+	}
+	ks.hostKeys = append(ks.hostKeys[:i], ks.hostKeys[i+1:]...)
+	delete(ks.hostCerts, string(key.Marshal()))
+	return nil
+}
+
+func (ks *sshKeystore) GetHostKeys(ctx context.Context) ([]ssh.Signer, error) {
+	ks.RLock()
+	defer ks.RUnlock()
+	if len(ks.hostKeys) == 0 {
+		return nil, ErrNoHostKey
+	}
+	signers := append([]ssh.Signer(nil), ks.hostKeys...)
+	for _, signer := range ks.hostKeys {
+		cert, ok := ks.hostCerts[string(signer.PublicKey().Marshal())]
+		if !ok {
+			continue
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, certSigner)
+	}
+	return signers, nil
+}
+
+func (ks *sshKeystore) SetHostCertificate(ctx context.Context, cert *ssh.Certificate) error {
+	if cert.CertType != ssh.HostCert {
+		return ErrNotHostCertificate
+	}
+	ks.Lock()
+	defer ks.Unlock()
+	if ks.hostKeyIndex(cert.Key) < 0 {
+		return ErrUnknownHostKey
+	}
+	if ks.hostCerts == nil {
+		ks.hostCerts = make(map[string]*ssh.Certificate)
+	}
+	ks.hostCerts[string(cert.Key.Marshal())] = cert
+	return nil
+}
+
+// hostKeyIndex returns the index of key in hostKeys, -1 if it is missing.
+// The keystore has to be locked.
+func (ks *sshKeystore) hostKeyIndex(key ssh.PublicKey) int {
+	for i, signer := range ks.hostKeys {
+		if bytes.Equal(signer.PublicKey().Marshal(), key.Marshal()) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SignHostKey issues a host certificate for key, valid for the host names
+// in principals. Clients trust it with a @cert-authority entry of ca in
+// their known_hosts.
+func SignHostKey(ca ssh.Signer, key ssh.PublicKey, principals []string, validity time.Duration) (*ssh.Certificate, error) {
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.HostCert,
+		KeyId:           ssh.FingerprintSHA256(key),
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(validity).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, fmt.Errorf("could not sign certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// hostSigners returns the signers used in the handshake. ssh.ServerConfig
+// holds one key per algorithm, further keys of an algorithm are only
+// announced with hostKeysRequest until the first one is removed.
+func hostSigners(signers []ssh.Signer) []ssh.Signer {
+	var active []ssh.Signer
+	types := make(map[string]bool)
+	for _, signer := range signers {
+		if keyType := signer.PublicKey().Type(); !types[keyType] {
+			types[keyType] = true
+			active = append(active, signer)
+		}
+	}
+	return active
+}
+
+// hostKeys returns the host keys of the keystore, the one of GetHostKey if
+// it is no HostKeyStore.
+func (s *Server) hostKeys(ctx context.Context) ([]ssh.Signer, error) {
+	if store, ok := s.keystore.(HostKeyStore); ok {
+		return store.GetHostKeys(ctx)
+	}
+	signer, err := s.keystore.GetHostKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []ssh.Signer{signer}, nil
+}
+
+// plainHostKeys returns the host keys without certificates.
+func plainHostKeys(signers []ssh.Signer) []ssh.Signer {
+	var plain []ssh.Signer
+	for _, signer := range signers {
+		if _, ok := signer.PublicKey().(*ssh.Certificate); !ok {
+			plain = append(plain, signer)
+		}
+	}
+	return plain
+}
+
+// announceHostKeys sends all host keys to the client, so OpenSSH clients
+// with UpdateHostKeys learn about new keys before the old ones are removed.
+func (s *Server) announceHostKeys(ctx context.Context, conn ssh.Conn) {
+	signers, err := s.hostKeys(ctx)
+	if err != nil {
+		s.logger.Error(ctx, "Could not load host keys: %s", err.Error())
+		return
+	}
+	var payload []byte
+	for _, signer := range plainHostKeys(signers) {
+		payload = append(payload, ssh.Marshal(struct{ Key []byte }{signer.PublicKey().Marshal()})...)
+	}
+	if _, _, err := conn.SendRequest(hostKeysRequest, false, payload); err != nil {
+		s.logger.Debug(ctx, "Could not announce host keys: %s", err.Error())
+	}
+}
+
+// handleGlobalRequests answers hostKeysProveRequest and refuses all other
+// global requests.
+func (s *Server) handleGlobalRequests(ctx context.Context, conn ssh.Conn, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		if req.Type != hostKeysProveRequest {
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+		signatures, err := s.proveHostKeys(ctx, conn.SessionID(), req.Payload)
+		if err != nil {
+			s.logger.Error(ctx, "Could not prove host keys: %s", err.Error())
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, signatures)
+	}
+}
+
+// proveHostKeys signs the session for every host key in payload. RSA keys
+// sign with rsa-sha2-512.
+func (s *Server) proveHostKeys(ctx context.Context, sessionID, payload []byte) ([]byte, error) {
+	signers, err := s.hostKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	signers = plainHostKeys(signers)
+	var signatures []byte
+	for len(payload) > 0 {
+		var key struct {
+			Blob []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(payload, &key); err != nil {
+			return nil, err
+		}
+		payload = key.Rest
+		var signer ssh.Signer
+		for _, candidate := range signers {
+			if bytes.Equal(candidate.PublicKey().Marshal(), key.Blob) {
+				signer = candidate
+			}
+		}
+		if signer == nil {
+			return nil, ErrUnknownHostKey
+		}
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, sessionID, key.Blob})
+		var signature *ssh.Signature
+		if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+			signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+		} else {
+			signature, err = signer.Sign(rand.Reader, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, ssh.Marshal(struct{ Signature []byte }{ssh.Marshal(signature)})...)
+	}
+	return signatures, nil
+}
+
+// hostKeyPlan holds the host keys described by HOSTKEYS. signers are the
+// keys of the keystore with the changes applied, the keystore itself is
+// only changed by applyHostKeys once the config was accepted.
+type hostKeyPlan struct {
+	signers []ssh.Signer
+	add     [][]byte
+	remove  []ssh.PublicKey
+	certs   []*ssh.Certificate
+	// files are the keys of HOSTKEYS.FILES, see Server.hostKeyFiles
+	files map[string]ssh.PublicKey
+}
+
+// planHostKeys reads the keys of HOSTKEYS.FILES and the certificates of
+// HOSTKEYS.CERTIFICATES, both comma separated paths. Certificates are in
+// the format of ssh-keygen, "*-cert.pub". Keys added by a previous config
+// and missing from FILES are removed again.
+func (s *Server) planHostKeys(ctx context.Context, conf *config.Config) (*hostKeyPlan, error) {
+	current, err := s.hostKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var keys []ssh.Signer
+	certSigners := make(map[string]ssh.Signer)
+	for _, signer := range current {
+		if cert, ok := signer.PublicKey().(*ssh.Certificate); ok {
+			certSigners[string(cert.Key.Marshal())] = signer
+		} else {
+			keys = append(keys, signer)
+		}
+	}
+	plan := &hostKeyPlan{files: make(map[string]ssh.PublicKey)}
+	hostKeyConfig, err := conf.GetConfig(ctx, "HOSTKEYS")
+	if err != nil || hostKeyConfig == nil {
+		plan.signers = current
+		plan.files = s.hostKeyFiles
+		return plan, nil
+	}
+
+	files, _ := hostKeyConfig.Get(ctx, "FILES")
+	certificates, _ := hostKeyConfig.Get(ctx, "CERTIFICATES")
+	if _, ok := s.keystore.(HostKeyStore); !ok && (files != "" || certificates != "") {
+		return nil, ErrSSHConfigReason{errors.New("keystore does not support multiple host keys")}
+	}
+	for _, path := range splitList(files) {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, ErrSSHConfigReason{fmt.Errorf("could not read host key: %w", err)}
+		}
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		if err != nil {
+			return nil, ErrSSHConfigReason{fmt.Errorf("could not parse host key %s: %w", path, err)}
+		}
+		id := string(signer.PublicKey().Marshal())
+		known := containsHostKey(keys, signer.PublicKey())
+		// keys set by other means than FILES are never removed
+		if _, ok := s.hostKeyFiles[id]; ok || !known {
+			plan.files[id] = signer.PublicKey()
+		}
+		if !known {
+			keys = append(keys, signer)
+			plan.add = append(plan.add, pemBytes)
+		}
+	}
+	for id, key := range s.hostKeyFiles {
+		if _, ok := plan.files[id]; ok {
+			continue
+		}
+		plan.remove = append(plan.remove, key)
+		delete(certSigners, id)
+		keys = slices.DeleteFunc(keys, func(signer ssh.Signer) bool {
+			return string(signer.PublicKey().Marshal()) == id
+		})
+	}
+
+	for _, path := range splitList(certificates) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, ErrSSHConfigReason{fmt.Errorf("could not read host certificate: %w", err)}
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+		if err != nil {
+			return nil, ErrSSHConfigReason{fmt.Errorf("could not parse host certificate %s: %w", path, err)}
+		}
+		cert, ok := key.(*ssh.Certificate)
+		if !ok || cert.CertType != ssh.HostCert {
+			return nil, ErrSSHConfigReason{fmt.Errorf("%s: %w", path, ErrNotHostCertificate)}
+		}
+		i := slices.IndexFunc(keys, func(signer ssh.Signer) bool {
+			return bytes.Equal(signer.PublicKey().Marshal(), cert.Key.Marshal())
+		})
+		if i < 0 {
+			return nil, ErrSSHConfigReason{fmt.Errorf("%s: %w", path, ErrUnknownHostKey)}
+		}
+		certSigner, err := ssh.NewCertSigner(cert, keys[i])
+		if err != nil {
+			return nil, ErrSSHConfigReason{fmt.Errorf("could not use host certificate %s: %w", path, err)}
+		}
+		certSigners[string(cert.Key.Marshal())] = certSigner
+		plan.certs = append(plan.certs, cert)
+	}
+
+	plan.signers = keys
+	for _, signer := range keys {
+		if certSigner, ok := certSigners[string(signer.PublicKey().Marshal())]; ok {
+			plan.signers = append(plan.signers, certSigner)
+		}
+	}
+	return plan, nil
+}
+
+// applyHostKeys stores the changes of plan in the keystore. Only a
+// HostKeyStore can have changes, see planHostKeys.
+func (s *Server) applyHostKeys(ctx context.Context, plan *hostKeyPlan) error {
+	store, ok := s.keystore.(HostKeyStore)
+	if !ok {
+		return nil
+	}
+	for _, pemBytes := range plan.add {
+		if err := store.AddHostKey(ctx, pemBytes); err != nil {
+			return fmt.Errorf("could not add host key: %w", err)
+		}
+	}
+	for _, key := range plan.remove {
+		if err := store.RemoveHostKey(ctx, key); err != nil && !errors.Is(err, ErrUnknownHostKey) {
+			return fmt.Errorf("could not remove host key: %w", err)
+		}
+	}
+	for _, cert := range plan.certs {
+		if err := store.SetHostCertificate(ctx, cert); err != nil {
+			return fmt.Errorf("could not set host certificate: %w", err)
+		}
+	}
+	s.hostKeyFiles = plan.files
+	return nil
+}
+
+// containsHostKey reports whether one of signers holds key.
+func containsHostKey(signers []ssh.Signer, key ssh.PublicKey) bool {
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated config value.
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
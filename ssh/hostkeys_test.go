@@ -0,0 +1,375 @@
+package ssh
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func hostKeyPEM(t *testing.T, private crypto.PrivateKey) []byte {
+	t.Helper()
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(block)
+}
+
+func ecdsaKeyPEM(t *testing.T) []byte {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hostKeyPEM(t, private)
+}
+
+func rsaKeyPEM(t *testing.T) []byte {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hostKeyPEM(t, private)
+}
+
+func publicKeyOf(t *testing.T, pemBytes []byte) ssh.PublicKey {
+	t.Helper()
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer.PublicKey()
+}
+
+func TestHostKeys(t *testing.T) {
+	ctx := context.Background()
+	ks, _ := newCAKeystore(t)
+	ecdsaKey := ecdsaKeyPEM(t)
+	if err := ks.AddHostKey(ctx, ecdsaKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.AddHostKey(ctx, ecdsaKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.AddHostKey(ctx, rsaKeyPEM(t)); err != nil {
+		t.Fatal(err)
+	}
+	signers, err := ks.GetHostKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, signers, 3)
+
+	caSigner, err := ssh.ParsePrivateKey(ecdsaKeyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := SignHostKey(caSigner, publicKeyOf(t, ecdsaKey), []string{"localhost"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SetHostCertificate(ctx, cert); err != nil {
+		t.Fatal(err)
+	}
+	signers, err = ks.GetHostKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, signers, 4)
+	assert.Equal(t, ssh.CertAlgoECDSA256v01, signers[3].PublicKey().Type())
+
+	unknown, err := SignHostKey(caSigner, publicKeyOf(t, ecdsaKeyPEM(t)), nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(t, ks.SetHostCertificate(ctx, unknown), ErrUnknownHostKey)
+	userCert, err := ks.SignUserKey(ctx, publicKeyOf(t, ecdsaKey), UserCertOptions{Principals: []string{"alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(t, ks.SetHostCertificate(ctx, userCert), ErrNotHostCertificate)
+
+	if err := ks.RemoveHostKey(ctx, publicKeyOf(t, ecdsaKey)); err != nil {
+		t.Fatal(err)
+	}
+	signers, err = ks.GetHostKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, signers, 2)
+	assert.ErrorIs(t, ks.RemoveHostKey(ctx, publicKeyOf(t, ecdsaKey)), ErrUnknownHostKey)
+}
+
+func TestLoadHostKeys(t *testing.T) {
+	ctx := context.Background()
+	ks, _ := newCAKeystore(t)
+	dir := t.TempDir()
+	ecdsaKey := ecdsaKeyPEM(t)
+	keyFile := filepath.Join(dir, "ssh_host_ecdsa_key")
+	if err := os.WriteFile(keyFile, ecdsaKey, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.ParsePrivateKey(ecdsaKeyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := SignHostKey(caSigner, publicKeyOf(t, ecdsaKey), []string{tADDRESS}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "ssh_host_ecdsa_key-cert.pub")
+	if err := os.WriteFile(certFile, ssh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"HOSTKEYS": map[string]interface{}{
+			"FILES":        keyFile,
+			"CERTIFICATES": certFile,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{keystore: ks}
+	loadHostKeys := func(conf *config.Config) error {
+		plan, err := server.planHostKeys(ctx, conf)
+		if err != nil {
+			return err
+		}
+		return server.applyHostKeys(ctx, plan)
+	}
+	if err := loadHostKeys(conf); err != nil {
+		t.Fatal(err)
+	}
+	signers, err := ks.GetHostKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, signers, 3)
+
+	conf, err = config.WithInitialValues(ctx, map[string]interface{}{
+		"HOSTKEYS": map[string]interface{}{
+			"FILES":        keyFile,
+			"CERTIFICATES": keyFile,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(t, loadHostKeys(conf), ErrSSHConfig)
+
+	// dropping the file rotates the key out, the keystore's own key stays
+	conf, err = config.WithInitialValues(ctx, map[string]interface{}{
+		"HOSTKEYS": map[string]interface{}{
+			"FILES": "",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadHostKeys(conf); err != nil {
+		t.Fatal(err)
+	}
+	signers, err = ks.GetHostKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, signers, 1) {
+		assert.Equal(t, ssh.KeyAlgoED25519, signers[0].PublicKey().Type())
+	}
+}
+
+func TestHostCertificate(t *testing.T) {
+	ctx := context.Background()
+	ks, caKeys := newCAKeystore(t)
+	ecdsaKey := ecdsaKeyPEM(t)
+	if err := ks.AddHostKey(ctx, ecdsaKey); err != nil {
+		t.Fatal(err)
+	}
+	hostCA, err := ssh.ParsePrivateKey(ecdsaKeyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := SignHostKey(hostCA, publicKeyOf(t, ecdsaKey), []string{tADDRESS}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SetHostCertificate(ctx, cert); err != nil {
+		t.Fatal(err)
+	}
+	addr := startCAServer(t, ks, caKeys, nil)
+	private, userCert, err := ks.GenerateUserKey(ctx, "carol", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func(hostCA ssh.PublicKey) error {
+		checker := &ssh.CertChecker{
+			IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+				return string(auth.Marshal()) == string(hostCA.Marshal())
+			},
+		}
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:              "carol",
+			Auth:              []ssh.AuthMethod{certSigner(t, private, userCert)},
+			HostKeyCallback:   checker.CheckHostKey,
+			HostKeyAlgorithms: []string{ssh.CertAlgoECDSA256v01},
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	assert.NoError(t, dial(hostCA.PublicKey()))
+	assert.Error(t, dial(publicKeyOf(t, ecdsaKeyPEM(t))))
+
+	// the plain ecdsa key is offered as well
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:              "carol",
+		Auth:              []ssh.AuthMethod{certSigner(t, private, userCert)},
+		HostKeyCallback:   ssh.FixedHostKey(publicKeyOf(t, ecdsaKey)),
+		HostKeyAlgorithms: []string{ssh.KeyAlgoECDSA256},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+}
+
+func TestHostKeysAnnouncement(t *testing.T) {
+	ctx := context.Background()
+	ks, caKeys := newCAKeystore(t)
+	if err := ks.AddHostKey(ctx, rsaKeyPEM(t)); err != nil {
+		t.Fatal(err)
+	}
+	addr := startCAServer(t, ks, caKeys, nil)
+	private, userCert, err := ks.GenerateUserKey(ctx, "carol", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            "carol",
+		Auth:            []ssh.AuthMethod{certSigner(t, private, userCert)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	go func() {
+		for newChannel := range chans {
+			newChannel.Reject(ssh.Prohibited, "test client")
+		}
+	}()
+
+	var announcement *ssh.Request
+	select {
+	case announcement = <-reqs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no host keys announced")
+	}
+	go ssh.DiscardRequests(reqs)
+	assert.Equal(t, hostKeysRequest, announcement.Type)
+	var keys []ssh.PublicKey
+	for rest := announcement.Payload; len(rest) > 0; {
+		var blob struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(rest, &blob); err != nil {
+			t.Fatal(err)
+		}
+		key, err := ssh.ParsePublicKey(blob.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		rest = blob.Rest
+	}
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, ssh.KeyAlgoED25519, keys[0].Type())
+		assert.Equal(t, ssh.KeyAlgoRSA, keys[1].Type())
+	}
+
+	ok, reply, err := clientConn.SendRequest(hostKeysProveRequest, true, announcement.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, ok)
+	for _, key := range keys {
+		var signature struct {
+			Blob []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(reply, &signature); err != nil {
+			t.Fatal(err)
+		}
+		reply = signature.Rest
+		sig := new(ssh.Signature)
+		if err := ssh.Unmarshal(signature.Blob, sig); err != nil {
+			t.Fatal(err)
+		}
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, clientConn.SessionID(), key.Marshal()})
+		assert.NoError(t, key.Verify(data, sig))
+	}
+
+	ok, _, err = clientConn.SendRequest(hostKeysProveRequest, true, ssh.Marshal(struct{ Key []byte }{publicKeyOf(t, rsaKeyPEM(t)).Marshal()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, ok)
+}
+
+// plainKeystore only implements Keystore.
+type plainKeystore struct {
+	Keystore
+}
+
+func TestPlainKeystore(t *testing.T) {
+	ctx := context.Background()
+	ks, caKeys := newCAKeystore(t)
+	addr := startCAServer(t, plainKeystore{ks}, caKeys, nil)
+	private, userCert, err := ks.GenerateUserKey(ctx, "carol", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, dialWith(addr, "carol", certSigner(t, private, userCert)))
+
+	keyFile := filepath.Join(t.TempDir(), "ssh_host_ecdsa_key")
+	if err := os.WriteFile(keyFile, ecdsaKeyPEM(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"HOSTKEYS": map[string]interface{}{
+			"FILES": keyFile,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = (&Server{keystore: plainKeystore{ks}}).planHostKeys(ctx, conf)
+	assert.ErrorIs(t, err, ErrSSHConfig)
+}
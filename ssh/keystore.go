@@ -8,6 +8,7 @@ import (
 	"crypto/rand"
 	"errors"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -25,14 +26,41 @@ type Keystore interface {
 	AddKnownHost(ctx context.Context, hostIdentifier string, key ssh.PublicKey) error
 	// checks if the given host is known
 	CheckKnownHost(ctx context.Context, hostIdentifier string, key ssh.PublicKey) (bool, error)
-	// Returns a new signed public key for a new user
-	// GenerateUserKey(ctx context.Context, username string) (ssh.PublicKey, error)
+}
+
+// HostKeyStore is implemented by keystores holding several host keys and
+// their certificates. The server requires it for HOSTKEYS.
+type HostKeyStore interface {
+	// adds another host key, e.g. of another algorithm or to announce a
+	// rotation
+	AddHostKey(ctx context.Context, pemBytes []byte) error
+	// removes the host key matching key
+	RemoveHostKey(ctx context.Context, key ssh.PublicKey) error
+	// returns all host keys as signers, followed by the certificates of
+	// certified keys
+	GetHostKeys(ctx context.Context) ([]ssh.Signer, error)
+	// attaches a host certificate to the host key it certifies
+	SetHostCertificate(ctx context.Context, cert *ssh.Certificate) error
+}
+
+// UserCAStore is implemented by keystores able to issue user certificates.
+type UserCAStore interface {
+	// sets the private key signing user certificates
+	SetUserCAKey(ctx context.Context, pemBytes []byte) error
+	// issues a user certificate for key
+	SignUserKey(ctx context.Context, key ssh.PublicKey, options UserCertOptions) (*ssh.Certificate, error)
+	// creates a key pair for a new user with a certificate valid for validity
+	GenerateUserKey(ctx context.Context, username string, validity time.Duration) (crypto.PrivateKey, *ssh.Certificate, error)
 }
 
 type sshKeystore struct {
 	sync.RWMutex
-	hostKey  ssh.Signer
-	userKeys map[string]ssh.PublicKey
+	// hostKeys[0] is the key of SetHostKey and GetHostKey
+	hostKeys    []ssh.Signer
+	hostCerts   map[string]*ssh.Certificate
+	userCA      ssh.Signer
+	userKeys    map[string]ssh.PublicKey
+	totpSecrets map[string][]byte
 }
 
 func NewKeystore(privatePemBytes []byte, passphrase string) (Keystore, error) {
@@ -51,8 +79,10 @@ func NewKeystore(privatePemBytes []byte, passphrase string) (Keystore, error) {
 	}
 
 	return &sshKeystore{
-		hostKey:  signer,
-		userKeys: make(map[string]ssh.PublicKey),
+		hostKeys:    []ssh.Signer{signer},
+		hostCerts:   make(map[string]*ssh.Certificate),
+		userKeys:    make(map[string]ssh.PublicKey),
+		totpSecrets: make(map[string][]byte),
 	}, nil
 }
 
@@ -61,7 +91,12 @@ func (ks *sshKeystore) SetHostKey(ctx context.Context, pemBytes []byte) error {
 		return err
 	} else {
 		ks.Lock()
-		ks.hostKey = signer
+		if len(ks.hostKeys) > 0 {
+			delete(ks.hostCerts, string(ks.hostKeys[0].PublicKey().Marshal()))
+			ks.hostKeys[0] = signer
+		} else {
+			ks.hostKeys = []ssh.Signer{signer}
+		}
 		ks.Unlock()
 		return nil
 	}
@@ -69,10 +104,10 @@ func (ks *sshKeystore) SetHostKey(ctx context.Context, pemBytes []byte) error {
 
 func (ks *sshKeystore) GetHostKey(ctx context.Context) (key ssh.Signer, err error) {
 	ks.RLock()
-	if ks.hostKey == nil {
+	if len(ks.hostKeys) == 0 {
 		err = ErrNoHostKey
 	} else {
-		key = ks.hostKey
+		key = ks.hostKeys[0]
 	}
 	ks.RUnlock()
 	return
@@ -110,16 +145,3 @@ func GenerateKey() (crypto.PrivateKey, error) {
 	}
 	return private, nil
 }
-
-// func (ks *SSHKeystore) GenerateUserKey(ctx context.Context, username string) (ssh.PublicKey, error) {
-// 	public, privatekey, err := ed25519.GenerateKey(rand.Reader)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	private, err := ssh.MarshalPrivateKey(s.privateKey, "")
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// }
@@ -6,19 +6,40 @@ import (
 	"errors"
 	"slices"
 
+	"github.com/myLogic207/gotils/config"
 	"golang.org/x/crypto/ssh"
 )
 
 func (s *Server) AuthLogCallback(conn ssh.ConnMetadata, method string, err error) {
+	var partial *ssh.PartialSuccessError
+	outcome := "success"
+	if errors.As(err, &partial) {
+		outcome = "partial"
+	} else if err != nil {
+		outcome = "failure"
+	}
+	s.metrics.authAttempts.With(s.metrics.name, method, outcome).Inc()
 	if err == nil {
 		s.logger.Info(context.Background(), "Connection from '%s' using '%s'", conn.RemoteAddr().String(), method)
+	} else if partial != nil {
+		s.logger.Info(context.Background(), "Connection from '%s' passed '%s', further methods required", conn.RemoteAddr().String(), method)
 	} else {
 		s.logger.Error(context.Background(), "Connection error from '%s' using '%s' auth: %s", conn.RemoteAddr().String(), method, err.Error())
 	}
 }
 
-// PublicKeyCallback handles public key authentication.
+// PublicKeyCallback handles public key authentication as first step of
+// the AuthPolicy of the user.
 func (s *Server) PublicKeyCallback(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	return s.authStep(c, nil, MethodPublicKey, func() (*ssh.Permissions, error) {
+		return s.verifyPublicKey(c, pubKey)
+	})
+}
+
+func (s *Server) verifyPublicKey(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		return s.verifyUserCertificate(c, cert)
+	}
 	if !slices.Contains(s.supportedKeyTypes, pubKey.Type()) {
 		s.logger.Error(context.Background(), "Unsupported key type '%s'", pubKey.Type())
 		return nil, ErrKeyNotSupported
@@ -41,9 +62,23 @@ func (s *Server) PublicKeyCallback(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*s
 	}, nil
 }
 
-// PasswordAuth handles password authentication.
+// PasswordAuth handles password authentication against the configured
+// CredentialStore as first step of the AuthPolicy of the user.
 func (s *Server) PasswordAuth(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
-	return nil, ErrAuthFailedReason{errors.New("authentication method not supported")}
+	return s.authStep(conn, nil, MethodPassword, func() (*ssh.Permissions, error) {
+		return s.verifyPassword(conn, password)
+	})
+}
+
+func (s *Server) verifyPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	auth := s.authSettings()
+	if auth == nil || auth.credentials == nil {
+		return nil, ErrAuthFailedReason{errors.New("authentication method not supported")}
+	}
+	if err := auth.credentials.Verify(context.Background(), conn.User(), password); err != nil {
+		return nil, authError(err)
+	}
+	return newPermissions(), nil
 }
 
 // NoAuthCallback handles scenarios where no authentication method is supported.
@@ -51,7 +86,114 @@ func (s *Server) NoAuthCallback(conn ssh.ConnMetadata) (*ssh.Permissions, error)
 	return nil, ErrAuthFailedReason{errors.New("authentication method not supported")}
 }
 
-// KeyboardInteractiveAuth handles keyboard-interactive authentication.
+// KeyboardInteractiveAuth handles keyboard-interactive authentication by
+// running the configured InteractiveAuth, as first step of the AuthPolicy
+// of the user.
 func (s *Server) KeyboardInteractiveAuth(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
-	return nil, ErrAuthFailedReason{errors.New("authentication method not supported")}
+	return s.authStep(conn, nil, MethodKeyboardInteractive, func() (*ssh.Permissions, error) {
+		return s.verifyKeyboardInteractive(conn, challenge)
+	})
+}
+
+func (s *Server) verifyKeyboardInteractive(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	auth := s.authSettings()
+	if auth == nil || auth.interactive == nil {
+		return nil, ErrAuthFailedReason{errors.New("authentication method not supported")}
+	}
+	prompt := func(instruction string, questions ...Question) ([]string, error) {
+		prompts := make([]string, len(questions))
+		echos := make([]bool, len(questions))
+		for i, question := range questions {
+			prompts[i] = question.Prompt
+			echos[i] = question.Echo
+		}
+		return challenge(conn.User(), instruction, prompts, echos)
+	}
+	if err := auth.interactive(context.Background(), conn, prompt); err != nil {
+		return nil, authError(err)
+	}
+	return newPermissions(), nil
+}
+
+// authError wraps errors of an authentication method other than a plain
+// ErrAuthFailed.
+func authError(err error) error {
+	if errors.Is(err, ErrAuthFailed) {
+		return err
+	}
+	return ErrAuthFailedReason{err}
+}
+
+func newPermissions() *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			"permit-X11-forwarding":   "true",
+			"permit-agent-forwarding": "true",
+		},
+	}
+}
+
+// authSettings are the authentication methods besides public keys, Reload
+// replaces them as a whole.
+type authSettings struct {
+	credentials CredentialStore
+	totp        *TOTP
+	interactive InteractiveAuth
+	// secondFactor is set if interactive checks TOTP codes, which must not
+	// be the first method of a login
+	secondFactor bool
+	policies     AuthPolicyProvider
+	userCAs      []ssh.PublicKey
+}
+
+func (s *Server) authSettings() *authSettings {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.auth
+}
+
+// loadAuth creates the authentication settings described by conf. The
+// stores and verifiers of current are kept if their settings did not
+// change.
+func (s *Server) loadAuth(ctx context.Context, conf *config.Config, current *authSettings) (*authSettings, error) {
+	if current == nil {
+		current = &authSettings{}
+	}
+	credentials, err := s.loadCredentials(ctx, conf, current.credentials)
+	if err != nil {
+		return nil, err
+	}
+	totp, err := loadTOTP(ctx, conf, current.totp)
+	if err != nil {
+		return nil, err
+	}
+	policies := s.AuthPolicies
+	if policies == nil {
+		if policies, err = loadAuthPolicies(ctx, conf); err != nil {
+			return nil, err
+		}
+	}
+	userCAs, err := s.loadUserCAs(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	auth := &authSettings{credentials: credentials, totp: totp, interactive: s.KeyboardInteractive, policies: policies, userCAs: userCAs}
+	if auth.interactive == nil && totp != nil {
+		secrets := s.TOTPSecrets
+		if secrets == nil {
+			secrets, _ = s.keystore.(TOTPSecretStore)
+		}
+		if secrets == nil {
+			return nil, ErrSSHConfigReason{errors.New("totp enabled without a secret store")}
+		}
+		// failed codes count towards the password lockout
+		auth.interactive = totpAuth(totp, secrets, lockoutOf(credentials))
+		auth.secondFactor = true
+		if policies, ok := policies.(*configPolicies); ok {
+			if err := policies.checkSecondFactor(); err != nil {
+				return nil, ErrSSHConfigReason{err}
+			}
+		}
+	}
+	return auth, nil
 }
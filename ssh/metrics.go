@@ -0,0 +1,40 @@
+package ssh
+
+import (
+	"context"
+
+	"github.com/myLogic207/pepper/metrics"
+)
+
+// sshMetrics are the SSH specific instruments, the connection metrics are
+// recorded by the base server. All of them are nil if Server.Metrics is not
+// set.
+type sshMetrics struct {
+	name         string
+	authAttempts *metrics.CounterVec
+	channels     *metrics.CounterVec
+	requests     *metrics.CounterVec
+}
+
+func (s *Server) initMetrics(ctx context.Context) error {
+	s.metrics = sshMetrics{name: "ssh"}
+	if serverConfig, err := s.config.GetConfig(ctx, "SERVER"); err == nil && serverConfig != nil {
+		if metricsConfig, err := serverConfig.GetConfig(ctx, "METRICS"); err == nil && metricsConfig != nil {
+			if name, _ := metricsConfig.Get(ctx, "NAME"); name != "" {
+				s.metrics.name = name
+			}
+		}
+	}
+
+	var err error
+	if s.metrics.authAttempts, err = s.Metrics.CounterVec("pepper_ssh_auth_attempts_total", "Authentication attempts per method and outcome.", "server", "method", "outcome"); err != nil {
+		return err
+	}
+	if s.metrics.channels, err = s.Metrics.CounterVec("pepper_ssh_channels_total", "Channels opened by clients per type.", "server", "type"); err != nil {
+		return err
+	}
+	if s.metrics.requests, err = s.Metrics.CounterVec("pepper_ssh_requests_total", "Channel requests per type.", "server", "type"); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package ssh
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/myLogic207/pepper/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	_, client := startTestServer(t, "metrics", &Server{Metrics: registry}, nil)
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the default request handler refuses all requests
+	assert.Error(t, session.Setenv("LANG", "C"))
+	session.Close()
+
+	buff := &bytes.Buffer{}
+	if _, err := registry.WriteTo(buff); err != nil {
+		t.Fatal(err)
+	}
+	exposed := buff.String()
+	assert.Contains(t, exposed, `pepper_ssh_auth_attempts_total{server="ssh",method="publickey",outcome="success"} 1`)
+	assert.Contains(t, exposed, `pepper_ssh_channels_total{server="ssh",type="session"} 1`)
+	assert.Contains(t, exposed, `pepper_ssh_requests_total{server="ssh",type="env"} 1`)
+	assert.Contains(t, exposed, `pepper_connections_accepted_total{server="ssh",listener="default"} 1`)
+}
@@ -0,0 +1,59 @@
+package ssh
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	disconnectTooManyConns    = 12
+	overloadDisconnectMessage = "too many connections"
+	goodbyeBlockSize          = 8
+	goodbyeMinPaddingLength   = 4
+	goodbyeDrainTimeout       = time.Second
+)
+
+type disconnectMsg struct {
+	Reason   uint32 `sshtype:"1"`
+	Message  string
+	Language string
+}
+
+// goodbye sends the server version followed by an unencrypted
+// SSH_MSG_DISCONNECT to connections rejected because the server is
+// overloaded, so clients report the reason instead of a reset connection.
+func (s *Server) goodbye(ctx context.Context, conn net.Conn) error {
+	payload := ssh.Marshal(&disconnectMsg{
+		Reason:  disconnectTooManyConns,
+		Message: overloadDisconnectMessage,
+	})
+	padding := goodbyeBlockSize - (5+len(payload))%goodbyeBlockSize
+	if padding < goodbyeMinPaddingLength {
+		padding += goodbyeBlockSize
+	}
+
+	packet := []byte(s.serverConfig().ServerVersion + "\r\n")
+	packet = binary.BigEndian.AppendUint32(packet, uint32(1+len(payload)+padding))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+	if _, err := conn.Write(packet); err != nil {
+		return err
+	}
+
+	// closing with unread client data resets the connection, possibly before
+	// the client read the disconnect, so wait for the client to hang up
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+		closer.CloseWrite()
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(goodbyeDrainTimeout)); err != nil {
+		return err
+	}
+	_, err := io.Copy(io.Discard, conn)
+	return err
+}
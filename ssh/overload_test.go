@@ -0,0 +1,34 @@
+package ssh
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestOverloadGoodbye(t *testing.T) {
+	listener, err := net.Listen("tcp", tADDRESS+":"+tPORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	server := &Server{sshConfig: &ssh.ServerConfig{ServerVersion: "SSH-2.0-PEPPER"}}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		server.goodbye(context.Background(), conn)
+	}()
+
+	_, err = ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	assert.ErrorContains(t, err, "too many connections")
+}
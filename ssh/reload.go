@@ -0,0 +1,88 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/myLogic207/pepper/base"
+	"github.com/myLogic207/pepper/internal/confutil"
+)
+
+// Reload applies a new config to the running server. MAXAUTHTRIES,
+// SERVERVERSION, BANNER, PASSWORD, TOTP, AUTH, USERCA and HOSTKEYS apply to
+// new connections, the SERVER section is passed to base.Server.Reload.
+// Changes to KEY and LOGGER are reported in a *base.IgnoredKeysError, as are
+// the SERVER keys ignored by the base server.
+func (s *Server) Reload(ctx context.Context, serverOptions *config.Config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	next, err := config.WithInitialValuesAndOptions(ctx, defaultServerConfig, serverOptions)
+	if err != nil {
+		return fmt.Errorf("could not initialize config: %w", err)
+	}
+	auth, err := s.loadAuth(ctx, next, s.authSettings())
+	if err != nil {
+		return err
+	}
+	hostKeys, err := s.planHostKeys(ctx, next)
+	if err != nil {
+		return err
+	}
+	sshConfig, err := s.loadSSHConfig(ctx, next, auth, hostKeys)
+	if err != nil {
+		return err
+	}
+	banner, _ := next.Get(ctx, "BANNER")
+
+	var keys []string
+	for _, key := range confutil.Keys(defaultServerConfig) {
+		if key == "KEY" || strings.HasPrefix(key, "LOGGER.") {
+			keys = append(keys, key)
+		}
+	}
+	ignored := confutil.Changed(ctx, s.config, next, keys)
+
+	serverConfig, _ := next.GetConfig(ctx, "SERVER")
+	var baseIgnored *base.IgnoredKeysError
+	if err := s.base.Reload(ctx, serverConfig); errors.As(err, &baseIgnored) {
+		for _, key := range baseIgnored.Keys {
+			ignored = append(ignored, "SERVER."+key)
+		}
+	} else if err != nil {
+		return err
+	}
+	// the SERVER section is applied at this point, the previous SSH
+	// settings are kept
+	if err := s.applyHostKeys(ctx, hostKeys); err != nil {
+		return err
+	}
+
+	s.settingsMu.Lock()
+	s.sshConfig = sshConfig
+	s.banner = banner
+	s.auth = auth
+	s.config = next
+	s.settingsMu.Unlock()
+	s.logger.Info(ctx, "Reloaded SSH config")
+	if len(ignored) > 0 {
+		return &base.IgnoredKeysError{Keys: ignored}
+	}
+	return nil
+}
+
+// ReloadOnSignal reloads the server with the config returned by load
+// whenever one of signals, SIGHUP if none are given, is received. It blocks
+// until ctx is done.
+func (s *Server) ReloadOnSignal(ctx context.Context, load func(context.Context) (*config.Config, error), signals ...os.Signal) {
+	base.OnSignal(ctx, s.logger, func(ctx context.Context) error {
+		conf, err := load(ctx)
+		if err != nil {
+			return fmt.Errorf("could not load config: %w", err)
+		}
+		return s.Reload(ctx, conf)
+	}, signals...)
+}
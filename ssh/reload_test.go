@@ -0,0 +1,162 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/myLogic207/pepper/base"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestReload(t *testing.T) {
+	server, client := startTestServer(t, "reload", nil, nil)
+	assert.Equal(t, "SSH-2.0-PEPPER", string(client.ServerVersion()))
+
+	conf, err := config.WithInitialValues(context.Background(), map[string]interface{}{
+		"SERVER": map[string]interface{}{
+			"ADDRESS": tADDRESS,
+			"PORT":    tPORT,
+		},
+		"KEY":           "rotated",
+		"SERVERVERSION": "SSH-2.0-PEPPER-RELOADED",
+		"BANNER":        "reloaded",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Reload(context.Background(), conf)
+	var ignored *base.IgnoredKeysError
+	if !errors.As(err, &ignored) {
+		t.Fatalf("expected ignored keys, got %v", err)
+	}
+	assert.Equal(t, []string{"KEY"}, ignored.Keys)
+
+	signer, err := keystore.GetHostKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", server.GetAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := bufio.NewReader(conn).ReadString('\n')
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "SSH-2.0-PEPPER-RELOADED\r\n", version)
+
+	banners := make(chan string, 1)
+	// the host key is not a known client key, the banner is sent before
+	// authentication fails
+	client, err = ssh.Dial("tcp", server.GetAddr().String(), &ssh.ClientConfig{
+		User: "reload",
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		BannerCallback: func(message string) error {
+			banners <- message
+			return nil
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		client.Close()
+	}
+	select {
+	case banner := <-banners:
+		assert.Equal(t, "reloaded", banner)
+	default:
+		t.Fatal("no banner received")
+	}
+}
+
+func TestReloadFailureKeepsHostKeys(t *testing.T) {
+	ctx := context.Background()
+	ks, caKeys := newCAKeystore(t)
+	var server *Server
+	addr := startCAServer(t, ks, caKeys, func(s *Server) {
+		server = s
+	})
+
+	keyFile := filepath.Join(t.TempDir(), "ssh_host_ecdsa_key")
+	if err := os.WriteFile(keyFile, ecdsaKeyPEM(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"SERVER": map[string]interface{}{
+			"ADDRESS": tADDRESS,
+			"PORT":    tPORT,
+			"TIMEOUT": "never",
+		},
+		"HOSTKEYS": map[string]interface{}{
+			"FILES": keyFile,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, server.Reload(ctx, conf))
+
+	signers, err := ks.GetHostKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, signers, 1)
+	_, err = ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:              "carol",
+		HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
+		HostKeyAlgorithms: []string{ssh.KeyAlgoECDSA256},
+	})
+	assert.ErrorContains(t, err, "no common algorithm")
+}
+
+var errKeystoreFull = errors.New("keystore full")
+
+// fullKeystore refuses to add host keys.
+type fullKeystore struct {
+	*sshKeystore
+}
+
+func (fk fullKeystore) AddHostKey(ctx context.Context, pemBytes []byte) error {
+	return errKeystoreFull
+}
+
+func TestReloadHostKeyFailure(t *testing.T) {
+	ctx := context.Background()
+	ks, caKeys := newCAKeystore(t)
+	var server *Server
+	addr := startCAServer(t, fullKeystore{ks}, caKeys, func(s *Server) {
+		server = s
+	})
+
+	keyFile := filepath.Join(t.TempDir(), "ssh_host_ecdsa_key")
+	if err := os.WriteFile(keyFile, ecdsaKeyPEM(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"SERVER": map[string]interface{}{
+			"ADDRESS": tADDRESS,
+			"PORT":    tPORT,
+		},
+		"HOSTKEYS": map[string]interface{}{
+			"FILES": keyFile,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(t, server.Reload(ctx, conf), errKeystoreFull)
+
+	_, err = ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:              "carol",
+		HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
+		HostKeyAlgorithms: []string{ssh.KeyAlgoECDSA256},
+	})
+	assert.ErrorContains(t, err, "no common algorithm")
+}
@@ -0,0 +1,72 @@
+package ssh
+
+import (
+	"context"
+
+	"github.com/myLogic207/pepper/base"
+	"golang.org/x/crypto/ssh"
+)
+
+const contextKeySession = contextKey("session")
+
+// SessionInfo describes the authenticated SSH connection a channel or
+// request handler is serving. The embedded ConnInfo describes the
+// underlying network connection.
+type SessionInfo struct {
+	*base.ConnInfo
+	User          string
+	ClientVersion string
+	SessionID     []byte
+	// Permissions are the permissions granted by the authentication
+	// callback.
+	Permissions *ssh.Permissions
+	// KeyFingerprint is the SHA256 fingerprint of the public key used to
+	// authenticate, empty for other authentication methods.
+	KeyFingerprint string
+	// CertAuthority is the fingerprint of the CA of the user certificate
+	// used to authenticate, empty for plain keys.
+	CertAuthority string
+	// ForceCommand replaces the commands of exec, shell and subsystem
+	// requests if set by the user certificate.
+	ForceCommand string
+}
+
+// Permits reports whether the user certificate grants extension, e.g.
+// permit-pty. Sessions not authenticated by a certificate are not
+// restricted.
+func (info *SessionInfo) Permits(extension string) bool {
+	if info.CertAuthority == "" {
+		return true
+	}
+	_, ok := info.Permissions.Extensions[extension]
+	return ok
+}
+
+// SessionFromContext returns the SSH session the handler is serving.
+func SessionFromContext(ctx context.Context) (*SessionInfo, bool) {
+	info, ok := ctx.Value(contextKeySession).(*SessionInfo)
+	return info, ok
+}
+
+func newSessionInfo(ctx context.Context, sshConn *ssh.ServerConn) *SessionInfo {
+	connInfo, ok := base.ConnFromContext(ctx)
+	if !ok {
+		connInfo = &base.ConnInfo{
+			RemoteAddr: sshConn.RemoteAddr(),
+			LocalAddr:  sshConn.LocalAddr(),
+		}
+	}
+	info := &SessionInfo{
+		ConnInfo:      connInfo,
+		User:          sshConn.User(),
+		ClientVersion: string(sshConn.ClientVersion()),
+		SessionID:     sshConn.SessionID(),
+		Permissions:   sshConn.Permissions,
+	}
+	if sshConn.Permissions != nil {
+		info.KeyFingerprint = sshConn.Permissions.CriticalOptions["pubkey-fp"]
+		info.CertAuthority = sshConn.Permissions.CriticalOptions[certAuthorityOption]
+		info.ForceCommand = sshConn.Permissions.CriticalOptions[CertForceCommand]
+	}
+	return info
+}
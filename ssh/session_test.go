@@ -0,0 +1,95 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestServer starts a dedicated server. server is set up before
+// Listen, handlers after Listen and before Serve, both may be nil. The
+// returned client is authenticated as user.
+func startTestServer(t *testing.T, user string, server *Server, handlers func(*Server)) (*Server, *ssh.Client) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+		"SERVER": map[string]interface{}{
+			"ADDRESS": tADDRESS,
+			"PORT":    tPORT,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server == nil {
+		server = &Server{}
+	}
+	if err := server.Listen(ctx, conf, keystore); err != nil {
+		t.Fatal(err)
+	}
+	if handlers != nil {
+		handlers(server)
+	}
+	go server.Serve(ctx)
+	t.Cleanup(func() {
+		server.Stop(ctx)
+	})
+
+	clientPublic, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPubKey, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPrivKey, err := ssh.NewSignerFromKey(clientPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keystore.AddKnownHost(ctx, user, clientPubKey); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := ssh.Dial("tcp", server.GetAddr().String(), &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientPrivKey)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return server, client
+}
+
+func TestSessionFromContext(t *testing.T) {
+	sessions := make(chan *SessionInfo, 1)
+	_, client := startTestServer(t, "session", nil, func(server *Server) {
+		server.ChannelHandlers["pepper-test"] = func(ctx context.Context, channel ssh.NewChannel) error {
+			session, _ := SessionFromContext(ctx)
+			sessions <- session
+			return channel.Reject(ssh.Prohibited, "test channel")
+		}
+	})
+	_, _, err := client.OpenChannel("pepper-test", nil)
+	assert.Error(t, err)
+
+	session := <-sessions
+	if session == nil {
+		t.Fatal("no session in channel context")
+	}
+	assert.Equal(t, "session", session.User)
+	assert.NotEmpty(t, session.KeyFingerprint)
+	assert.Equal(t, client.SessionID(), session.SessionID)
+	assert.Equal(t, client.LocalAddr().String(), session.RemoteAddr.String())
+	assert.Equal(t, "default", session.Listener)
+}
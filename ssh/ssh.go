@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"os/exec"
 	"strconv"
+	"sync"
 
 	"github.com/myLogic207/gotils/config"
 	"github.com/myLogic207/gotils/logger"
 	"github.com/myLogic207/pepper/base"
+	"github.com/myLogic207/pepper/metrics"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -23,10 +26,38 @@ var defaultServerConfig = map[string]interface{}{
 		"WORKERS": 100,
 		"TIMEOUT": "5s",
 		"TYPE":    "tcp",
+		"METRICS": map[string]interface{}{
+			"NAME": "ssh",
+		},
 	},
 	"KEY":           "autogenerated",
 	"MAXAUTHTRIES":  3,
 	"SERVERVERSION": "SSH-2.0-PEPPER",
+	"BANNER":        "",
+	"PASSWORD": map[string]interface{}{
+		"ENABLED":     false,
+		"FILE":        "",
+		"MAXFAILURES": 5,
+		"LOCKOUT":     "5m",
+	},
+	"TOTP": map[string]interface{}{
+		"ENABLED": false,
+		"DIGITS":  6,
+		"PERIOD":  "30s",
+		"SKEW":    1,
+	},
+	"AUTH": map[string]interface{}{
+		"METHODS": "",
+		"ORDERED": false,
+	},
+	"USERCA": map[string]interface{}{
+		"KEYS": "",
+		"FILE": "",
+	},
+	"HOSTKEYS": map[string]interface{}{
+		"FILES":        "",
+		"CERTIFICATES": "",
+	},
 }
 
 type ChannelHandler func(ctx context.Context, channel ssh.NewChannel) error
@@ -43,8 +74,14 @@ type Server struct {
 	supportedKeyTypes []string
 	// keystore is the keystore used to store the host key and client keys.
 	// it also provides the ability to generate new client keys.
-	keystore  Keystore
-	sshConfig *ssh.ServerConfig
+	keystore Keystore
+	// settingsMu guards the settings replaced by Reload, reloadMu serializes
+	// reloads
+	settingsMu sync.RWMutex
+	reloadMu   sync.Mutex
+	sshConfig  *ssh.ServerConfig
+	banner     string
+	auth       *authSettings
 	// ChannelHandlers allow overriding the built-in session handlers or provide
 	// extensions to the protocol, such as tcpip forwarding. By default only the
 	// "session" handler is enabled.
@@ -59,6 +96,27 @@ type Server struct {
 	SubsystemHandlers map[string]SubsystemHandler
 	BannerString      string
 	BannerFunc        func(conn ssh.ConnMetadata) string
+	// Credentials checks passwords if PASSWORD.ENABLED is set, e.g. a
+	// SQLStore. If nil, an HtpasswdStore reads PASSWORD.FILE.
+	Credentials CredentialStore
+	// KeyboardInteractive enables keyboard-interactive authentication with
+	// a custom flow, replacing the TOTP prompt.
+	KeyboardInteractive InteractiveAuth
+	// TOTPSecrets holds the secrets for TOTP.ENABLED, it defaults to the
+	// keystore if it implements TOTPSecretStore.
+	TOTPSecrets TOTPSecretStore
+	// AuthPolicies decides the methods users have to pass, replacing the
+	// AUTH section.
+	AuthPolicies AuthPolicyProvider
+	// TrustedUserCAs sign user certificates accepted for public key auth,
+	// in addition to the keys of USERCA.
+	TrustedUserCAs []ssh.PublicKey
+	// hostKeyFiles are the keys added from HOSTKEYS.FILES, by marshalled
+	// public key
+	hostKeyFiles map[string]ssh.PublicKey
+	// Metrics receives the SSH and connection metrics if set before Listen.
+	Metrics *metrics.Registry
+	metrics sshMetrics
 }
 
 func (s *Server) Listen(ctx context.Context, serverOptions *config.Config, keystore Keystore) error {
@@ -93,33 +151,62 @@ func (s *Server) Listen(ctx context.Context, serverOptions *config.Config, keyst
 	s.RequestHandlers = make(map[string]RequestHandler)
 	s.SubsystemHandlers = make(map[string]SubsystemHandler)
 
-	sshConfig, err := s.loadSSHConfig(ctx)
+	auth, err := s.loadAuth(ctx, s.config, nil)
 	if err != nil {
 		return err
 	}
-	signer, err := s.keystore.GetHostKey(ctx)
+	hostKeys, err := s.planHostKeys(ctx, s.config)
 	if err != nil {
 		return err
 	}
-	sshConfig.AddHostKey(signer)
+	sshConfig, err := s.loadSSHConfig(ctx, s.config, auth, hostKeys)
+	if err != nil {
+		return err
+	}
+	if err := s.applyHostKeys(ctx, hostKeys); err != nil {
+		return err
+	}
 	s.sshConfig = sshConfig
+	s.auth = auth
+	s.banner, _ = s.config.Get(ctx, "BANNER")
 	s.logger.Info(ctx, "SSH Config loaded")
 
+	if err := s.initMetrics(ctx); err != nil {
+		return fmt.Errorf("could not register metrics: %w", err)
+	}
+	if s.base.Metrics == nil {
+		s.base.Metrics = s.Metrics
+	}
+	if s.base.Goodbye == nil {
+		s.base.Goodbye = s.goodbye
+	}
 	serverConfig, _ := s.config.GetConfig(ctx, "SERVER")
 	return s.base.Listen(ctx, serverConfig)
 }
 
+// Use registers middlewares wrapping the SSH connection handler, see
+// base.Server.Use.
+func (s *Server) Use(middlewares ...base.Middleware) {
+	if s.base == nil {
+		s.base = &base.Server{}
+	}
+	s.base.Use(middlewares...)
+}
+
 func (s *Server) Serve(ctx context.Context) error {
 	return s.base.Serve(ctx, s.connHandler)
 }
 
 func (s *Server) connHandler(ctx context.Context, conn net.Conn) error {
-	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshConfig)
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.serverConfig())
 	if err != nil {
 		return err
 	}
-	go ssh.DiscardRequests(reqs)
+	go s.handleGlobalRequests(ctx, sshConn, reqs)
 	defer sshConn.Close()
+	s.announceHostKeys(ctx, sshConn)
+	base.Annotate(ctx, "user", sshConn.User())
+	ctx = context.WithValue(ctx, contextKeySession, newSessionInfo(ctx, sshConn))
 
 	if err := s.WorkConnect(ctx, sshConn, chans); err != nil {
 		return err
@@ -127,13 +214,23 @@ func (s *Server) connHandler(ctx context.Context, conn net.Conn) error {
 	return nil
 }
 
-func (s *Server) loadSSHConfig(ctx context.Context) (*ssh.ServerConfig, error) {
-	maxTriesRaw, _ := s.config.Get(ctx, "MAXAUTHTRIES")
+// serverConfig returns the current SSH config, which Reload may replace.
+func (s *Server) serverConfig() *ssh.ServerConfig {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.sshConfig
+}
+
+// loadSSHConfig creates the SSH config described by conf with the host keys
+// of hostKeys. Password and keyboard-interactive auth are offered as enabled
+// in auth.
+func (s *Server) loadSSHConfig(ctx context.Context, conf *config.Config, auth *authSettings, hostKeys *hostKeyPlan) (*ssh.ServerConfig, error) {
+	maxTriesRaw, _ := conf.Get(ctx, "MAXAUTHTRIES")
 	maxTries, err := strconv.Atoi(maxTriesRaw)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse max auth tries: %w", err)
 	}
-	version, _ := s.config.Get(ctx, "SERVERVERSION")
+	version, _ := conf.Get(ctx, "SERVERVERSION")
 	sshConfig := &ssh.ServerConfig{
 		NoClientAuth:         false,
 		MaxAuthTries:         maxTries,
@@ -141,15 +238,21 @@ func (s *Server) loadSSHConfig(ctx context.Context) (*ssh.ServerConfig, error) {
 		AuthLogCallback:      s.AuthLogCallback,
 		PublicKeyCallback:    s.PublicKeyCallback,
 		NoClientAuthCallback: s.NoAuthCallback,
-		PasswordCallback:     s.PasswordAuth,
-		// KeyboardInteractiveCallback: s.loginManager.KeyboardInteractiveAuth,
-		BannerCallback: s.generateBanner,
+		BannerCallback:       s.generateBanner,
 		PublicKeyAuthAlgorithms: []string{
 			ssh.KeyAlgoED25519,
 			ssh.KeyAlgoRSA,
 		},
 	}
-
+	if auth.credentials != nil {
+		sshConfig.PasswordCallback = s.PasswordAuth
+	}
+	if auth.interactive != nil {
+		sshConfig.KeyboardInteractiveCallback = s.KeyboardInteractiveAuth
+	}
+	for _, signer := range hostSigners(hostKeys.signers) {
+		sshConfig.AddHostKey(signer)
+	}
 	return sshConfig, nil
 }
 
@@ -170,15 +273,59 @@ func (s *Server) generateBanner(conn ssh.ConnMetadata) string {
 		return s.BannerFunc(conn)
 	} else if s.BannerString != "" {
 		return s.BannerString
-	} else {
-		return fmt.Sprintf("SSH-2.0-%s", s.sshConfig.ServerVersion)
 	}
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	if s.banner != "" {
+		return s.banner
+	}
+	return fmt.Sprintf("SSH-2.0-%s", s.sshConfig.ServerVersion)
 }
 
 func (s *Server) Stop(ctx context.Context) error {
 	return s.base.Stop(ctx)
 }
 
+// Shutdown gracefully stops the server, see base.Server.Shutdown. Open
+// sessions are allowed to finish until ctx expires.
+func (s *Server) Shutdown(ctx context.Context) (base.DrainReport, error) {
+	return s.base.Shutdown(ctx)
+}
+
+// Handoff passes the listening sockets to cmd, usually a new version of the
+// binary, and gracefully shuts the server down, see base.Server.Handoff.
+func (s *Server) Handoff(ctx context.Context, cmd *exec.Cmd) (base.DrainReport, error) {
+	return s.base.Handoff(ctx, cmd)
+}
+
+// Connections returns the active connections, annotated with the user and
+// the number of open channels, see base.Server.Connections.
+func (s *Server) Connections() []base.ConnectionInfo {
+	return s.base.Connections()
+}
+
+// Kill closes a connection, see base.Server.Kill.
+func (s *Server) Kill(ctx context.Context, id base.ConnID) error {
+	return s.base.Kill(ctx, id)
+}
+
+// StartCapture records the stream of a connection, see
+// base.Server.StartCapture. The capture holds the encrypted SSH transport.
+func (s *Server) StartCapture(ctx context.Context, id base.ConnID) (string, error) {
+	return s.base.StartCapture(ctx, id)
+}
+
+// StopCapture ends the capture of a connection, see base.Server.StopCapture.
+func (s *Server) StopCapture(ctx context.Context, id base.ConnID) error {
+	return s.base.StopCapture(ctx, id)
+}
+
+// GetAddr returns the address of the first listener.
 func (s *Server) GetAddr() net.Addr {
-	return s.base.GetListener().Addr()
+	return s.base.Addrs()[0]
+}
+
+// Addrs returns the addresses of all listeners.
+func (s *Server) Addrs() []net.Addr {
+	return s.base.Addrs()
 }
@@ -10,6 +10,8 @@ import (
 	"testing"
 
 	"github.com/myLogic207/gotils/config"
+	"github.com/myLogic207/pepper/base"
+	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -115,3 +117,52 @@ func TestConnect(t *testing.T) {
 	}
 
 }
+
+func TestConnections(t *testing.T) {
+	clientPublic, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPubKey, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPrivKey, err := ssh.NewSignerFromKey(clientPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keystore.AddKnownHost(context.Background(), "registry", clientPubKey); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := ssh.Dial("tcp", TESTSERVER.GetAddr().String(), &ssh.ClientConfig{
+		User:            "registry",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientPrivKey)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	var info *base.ConnectionInfo
+	for _, conn := range TESTSERVER.Connections() {
+		if conn.Annotations["user"] == "registry" {
+			info = &conn
+		}
+	}
+	if info == nil {
+		t.Fatal("connection not registered")
+	}
+	assert.Equal(t, "1", info.Annotations["channels"])
+
+	if err := TESTSERVER.Kill(context.Background(), info.ID); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, client.Wait())
+}
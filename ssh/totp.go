@@ -0,0 +1,310 @@
+package ssh
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/myLogic207/gotils/config"
+	"github.com/myLogic207/pepper/dbconnect"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrNoTOTPSecret indicates that no TOTP secret is stored for a user.
+	ErrNoTOTPSecret = errors.New("no totp secret for user")
+)
+
+// Question is asked during keyboard-interactive authentication. Echo shows
+// the answer while it is typed.
+type Question struct {
+	Prompt string
+	Echo   bool
+}
+
+// Prompter sends questions to the client and returns the answers, in the
+// same order.
+type Prompter func(instruction string, questions ...Question) ([]string, error)
+
+// InteractiveAuth runs a keyboard-interactive question and answer flow. It
+// may prompt several times and returns nil if the user is authenticated,
+// ErrAuthFailed otherwise.
+type InteractiveAuth func(ctx context.Context, conn ssh.ConnMetadata, prompt Prompter) error
+
+// TOTPSecretStore holds the TOTP secrets of users.
+type TOTPSecretStore interface {
+	// TOTPSecret returns the secret of user, ErrNoTOTPSecret if there is
+	// none.
+	TOTPSecret(ctx context.Context, user string) ([]byte, error)
+	SetTOTPSecret(ctx context.Context, user string, secret []byte) error
+}
+
+// TOTP verifies RFC 6238 time-based one-time passwords using HMAC-SHA1.
+// Every code is accepted once per user. A zero Digits or Period falls back
+// to 6 digits and 30 seconds.
+type TOTP struct {
+	Digits int
+	Period time.Duration
+	// Skew is the number of periods a code may be early or late.
+	Skew int
+	mu   sync.Mutex
+	used map[string]uint64
+}
+
+// NewTOTP creates a verifier, the common authenticator apps use 6 digits
+// and a period of 30 seconds.
+func NewTOTP(digits int, period time.Duration, skew int) *TOTP {
+	return &TOTP{Digits: digits, Period: period, Skew: skew, used: make(map[string]uint64)}
+}
+
+func (t *TOTP) digits() int {
+	if t.Digits == 0 {
+		return 6
+	}
+	return t.Digits
+}
+
+func (t *TOTP) period() time.Duration {
+	if t.Period < time.Second {
+		return 30 * time.Second
+	}
+	return t.Period
+}
+
+func (t *TOTP) counter(at time.Time) uint64 {
+	return uint64(at.Unix() / int64(t.period()/time.Second))
+}
+
+// Code returns the code of secret at the given time.
+func (t *TOTP) Code(secret []byte, at time.Time) string {
+	return t.code(secret, t.counter(at))
+}
+
+// code implements the HOTP algorithm of RFC 4226.
+func (t *TOTP) code(secret []byte, counter uint64) string {
+	mac := hmac.New(sha1.New, secret)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < t.digits(); i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", t.digits(), value%modulo)
+}
+
+// Verify reports whether code is valid for secret within the skew window
+// around now. A code of a period that was already used by user, or of an
+// earlier one, is rejected.
+func (t *TOTP) Verify(user string, secret []byte, code string, now time.Time) bool {
+	current := t.counter(now)
+	var matched uint64
+	found := 0
+	for i := -t.Skew; i <= t.Skew; i++ {
+		counter := current + uint64(i)
+		if i < 0 && uint64(-i) > current {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(t.code(secret, counter)), []byte(code)) == 1 {
+			matched = counter
+			found = 1
+		}
+	}
+	if found == 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.used[user]; ok && matched <= last {
+		return false
+	}
+	if t.used == nil {
+		t.used = make(map[string]uint64)
+	}
+	t.used[user] = matched
+	return true
+}
+
+// GenerateTOTPSecret returns a random 160 bit secret.
+func GenerateTOTPSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// KeyURI returns the otpauth:// URI to provision secret in an
+// authenticator app, usually shown as QR code.
+func (t *TOTP) KeyURI(issuer, user string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", totpEncoding.EncodeToString(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(t.digits()))
+	query.Set("period", strconv.Itoa(int(t.period()/time.Second)))
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + user,
+		RawQuery: query.Encode(),
+	}).String()
+}
+
+// TOTPAuth asks for a one-time password and verifies it with totp against
+// the secret of the user in secrets.
+func TOTPAuth(totp *TOTP, secrets TOTPSecretStore) InteractiveAuth {
+	return totpAuth(totp, secrets, nil)
+}
+
+// totpAuth is TOTPAuth counting failed codes in lockout, if not nil.
+func totpAuth(totp *TOTP, secrets TOTPSecretStore, lockout *lockout) InteractiveAuth {
+	return func(ctx context.Context, conn ssh.ConnMetadata, prompt Prompter) error {
+		answers, err := prompt("", Question{Prompt: "Verification code: "})
+		if err != nil {
+			return err
+		}
+		if len(answers) != 1 {
+			return ErrAuthFailed
+		}
+		// the code is asked for unknown users as well, so they can not be
+		// told apart
+		secret, err := secrets.TOTPSecret(ctx, conn.User())
+		if err != nil && !errors.Is(err, ErrNoTOTPSecret) {
+			return err
+		}
+		now := time.Now()
+		if lockout != nil && !lockout.lockedUntil(conn.User(), now).IsZero() {
+			return ErrLockedOut
+		}
+		ok := err == nil && totp.Verify(conn.User(), secret, answers[0], now)
+		if lockout != nil && err == nil {
+			lockout.record(conn.User(), ok, now)
+		}
+		if !ok {
+			return ErrAuthFailed
+		}
+		return nil
+	}
+}
+
+func (ks *sshKeystore) TOTPSecret(ctx context.Context, user string) ([]byte, error) {
+	ks.RLock()
+	defer ks.RUnlock()
+	secret, ok := ks.totpSecrets[user]
+	if !ok {
+		return nil, ErrNoTOTPSecret
+	}
+	return secret, nil
+}
+
+func (ks *sshKeystore) SetTOTPSecret(ctx context.Context, user string, secret []byte) error {
+	ks.Lock()
+	ks.totpSecrets[user] = secret
+	ks.Unlock()
+	return nil
+}
+
+// SQLTOTPStore keeps base32 encoded TOTP secrets in a table with a user
+// and a secret column.
+type SQLTOTPStore struct {
+	db           *dbconnect.DB
+	builder      squirrel.StatementBuilderType
+	table        string
+	userColumn   string
+	secretColumn string
+}
+
+// NewSQLTOTPStore creates a store using secretColumn of the row of table
+// whose userColumn matches the user.
+func NewSQLTOTPStore(ctx context.Context, db *dbconnect.DB, table, userColumn, secretColumn string) *SQLTOTPStore {
+	return &SQLTOTPStore{
+		db:           db,
+		builder:      dbconnect.NewBuilder(ctx, db.Type(ctx)),
+		table:        table,
+		userColumn:   userColumn,
+		secretColumn: secretColumn,
+	}
+}
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (ss *SQLTOTPStore) TOTPSecret(ctx context.Context, user string) ([]byte, error) {
+	var encoded string
+	err := ss.builder.
+		Select(ss.secretColumn).
+		From(ss.table).
+		Where(squirrel.Eq{ss.userColumn: user}).
+		RunWith(ss.db.DB).QueryRowContext(ctx).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoTOTPSecret
+	} else if err != nil {
+		return nil, fmt.Errorf("could not query totp secret: %w", err)
+	}
+	secret, err := totpEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode totp secret: %w", err)
+	}
+	return secret, nil
+}
+
+// SetTOTPSecret updates the secret of an existing row of user.
+func (ss *SQLTOTPStore) SetTOTPSecret(ctx context.Context, user string, secret []byte) error {
+	result, err := ss.builder.
+		Update(ss.table).
+		Set(ss.secretColumn, totpEncoding.EncodeToString(secret)).
+		Where(squirrel.Eq{ss.userColumn: user}).
+		RunWith(ss.db.DB).ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("could not store totp secret: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("could not store totp secret: %w", sql.ErrNoRows)
+	}
+	return nil
+}
+
+// loadTOTP creates the verifier configured in the TOTP section, nil if it
+// is disabled. current is reused if the settings are the same, so used
+// codes are not accepted again after a reload.
+func loadTOTP(ctx context.Context, conf *config.Config, current *TOTP) (*TOTP, error) {
+	totpConfig, err := conf.GetConfig(ctx, "TOTP")
+	if err != nil || totpConfig == nil {
+		return nil, nil
+	}
+	if enabled, _ := totpConfig.Get(ctx, "ENABLED"); enabled != "true" {
+		return nil, nil
+	}
+	digitsRaw, _ := totpConfig.Get(ctx, "DIGITS")
+	digits, err := strconv.Atoi(digitsRaw)
+	if err != nil || digits < 6 || digits > 8 {
+		return nil, ErrSSHConfigReason{fmt.Errorf("invalid totp digits: %s", digitsRaw)}
+	}
+	periodRaw, _ := totpConfig.Get(ctx, "PERIOD")
+	period, err := time.ParseDuration(periodRaw)
+	if err != nil || period < time.Second {
+		return nil, ErrSSHConfigReason{fmt.Errorf("invalid totp period: %s", periodRaw)}
+	}
+	skewRaw, _ := totpConfig.Get(ctx, "SKEW")
+	skew, err := strconv.Atoi(skewRaw)
+	if err != nil || skew < 0 {
+		return nil, ErrSSHConfigReason{fmt.Errorf("invalid totp skew: %s", skewRaw)}
+	}
+	if current != nil && current.Digits == digits && current.Period == period && current.Skew == skew {
+		return current, nil
+	}
+	return NewTOTP(digits, period, skew), nil
+}
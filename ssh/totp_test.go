@@ -0,0 +1,161 @@
+package ssh
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/myLogic207/gotils/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors of RFC 6238, appendix B
+	secret := []byte("12345678901234567890")
+	totp := NewTOTP(8, 30*time.Second, 0)
+	for unix, code := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1234567890:  "89005924",
+		20000000000: "65353130",
+	} {
+		assert.Equal(t, code, totp.Code(secret, time.Unix(unix, 0)))
+	}
+}
+
+func TestTOTPVerify(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp := NewTOTP(6, 30*time.Second, 1)
+	now := time.Unix(1700000000, 0)
+
+	assert.False(t, totp.Verify("alice", secret, totp.Code(secret, now.Add(-time.Minute)), now))
+	assert.True(t, totp.Verify("alice", secret, totp.Code(secret, now.Add(-30*time.Second)), now))
+	assert.True(t, totp.Verify("alice", secret, totp.Code(secret, now), now))
+	// replayed and older codes are rejected, other users are independent
+	assert.False(t, totp.Verify("alice", secret, totp.Code(secret, now), now))
+	assert.False(t, totp.Verify("alice", secret, totp.Code(secret, now.Add(-30*time.Second)), now))
+	assert.True(t, totp.Verify("bob", secret, totp.Code(secret, now), now))
+	assert.True(t, totp.Verify("alice", secret, totp.Code(secret, now.Add(30*time.Second)), now))
+	assert.False(t, totp.Verify("alice", secret, "000000x", now))
+
+	assert.Contains(t, totp.KeyURI("pepper", "alice", secret), "otpauth://totp/pepper:alice?")
+}
+
+func TestKeyboardInteractiveTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keystore.(TOTPSecretStore).SetTOTPSecret(context.Background(), "alice", secret); err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{}
+	addr := startPolicyServer(t, server, map[string]interface{}{
+		"TOTP": map[string]interface{}{
+			"ENABLED": true,
+		},
+	})
+	otp := func(code string) ssh.AuthMethod {
+		return ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			assert.Equal(t, []string{"Verification code: "}, questions)
+			return []string{code}, nil
+		})
+	}
+	code := NewTOTP(6, 30*time.Second, 1).Code(secret, time.Now())
+
+	// a code alone is not enough, it is the second factor
+	assert.Error(t, dialWith(addr, "alice", otp(code)))
+	assert.NoError(t, dialWith(addr, "alice", ssh.Password("bcrypt-secret")))
+	assert.NoError(t, dialWith(addr, "alice", ssh.Password("bcrypt-secret"), otp(code)))
+	assert.Error(t, dialWith(addr, "unknown", otp(code)))
+}
+
+type staticSecrets map[string][]byte
+
+func (ss staticSecrets) TOTPSecret(ctx context.Context, user string) ([]byte, error) {
+	if secret, ok := ss[user]; ok {
+		return secret, nil
+	}
+	return nil, ErrNoTOTPSecret
+}
+
+func (ss staticSecrets) SetTOTPSecret(ctx context.Context, user string, secret []byte) error {
+	ss[user] = secret
+	return nil
+}
+
+type userConn struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (uc userConn) User() string {
+	return uc.user
+}
+
+func TestTOTPLockout(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewHtpasswdStore(writeHtpasswd(t), LockoutPolicy{MaxFailures: 2, Duration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp := NewTOTP(6, 30*time.Second, 1)
+	auth := totpAuth(totp, staticSecrets{"alice": secret}, store.lockout)
+	answer := func(code string) Prompter {
+		return func(instruction string, questions ...Question) ([]string, error) {
+			return []string{code}, nil
+		}
+	}
+	conn := userConn{user: "alice"}
+
+	assert.ErrorIs(t, auth(context.Background(), conn, answer("000000x")), ErrAuthFailed)
+	assert.ErrorIs(t, auth(context.Background(), conn, answer("000000x")), ErrAuthFailed)
+	// failed codes and passwords share the lockout
+	assert.False(t, store.LockedUntil(context.Background(), "alice").IsZero())
+	assert.ErrorIs(t, store.Verify(context.Background(), "alice", []byte("bcrypt-secret")), ErrLockedOut)
+	assert.ErrorIs(t, auth(context.Background(), conn, answer(totp.Code(secret, time.Now()))), ErrLockedOut)
+}
+
+func TestTOTPZeroValue(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(59, 0)
+	var totp TOTP
+	assert.Equal(t, NewTOTP(6, 30*time.Second, 0).Code(secret, now), totp.Code(secret, now))
+	assert.True(t, totp.Verify("alice", secret, totp.Code(secret, now), now))
+	assert.False(t, totp.Verify("alice", secret, totp.Code(secret, now), now))
+}
+
+func TestTOTPFirstMethodPolicy(t *testing.T) {
+	ctx := context.Background()
+	for ordered, valid := range map[bool]bool{false: true, true: false} {
+		conf, err := config.WithInitialValues(ctx, map[string]interface{}{
+			"TOTP": map[string]interface{}{
+				"ENABLED": true,
+				"DIGITS":  6,
+				"PERIOD":  "30s",
+				"SKEW":    1,
+			},
+			"AUTH": map[string]interface{}{
+				"METHODS": "keyboard-interactive,password",
+				"ORDERED": ordered,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = (&Server{keystore: keystore}).loadAuth(ctx, conf, nil)
+		if valid {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorIs(t, err, ErrSSHConfig)
+			assert.ErrorContains(t, err, "first method")
+		}
+	}
+}